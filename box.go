@@ -0,0 +1,392 @@
+// chris 101826 Self-describing container format.
+
+package steg
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// ErrBadHeader is returned when a box header is missing or corrupt.
+var ErrBadHeader = errors.New("bad box header")
+
+// ErrChecksum is returned at the end of a boxed payload whose checksum
+// doesn't match.
+var ErrChecksum = errors.New("box checksum mismatch")
+
+// Flags record how a boxed payload was prepared, so that extraction can
+// undo it.  Package steg itself doesn't act on them.
+type Flags uint8
+
+const (
+	// FlagCompressed marks a payload that was compressed.
+	FlagCompressed Flags = 1 << iota
+	// FlagSealed marks a payload that was sealed with package seal.
+	FlagSealed
+	// FlagFEC marks a body that was encoded with package fec after
+	// boxing, with Header.FECParity parity bytes per codeword.
+	FlagFEC
+
+	// flagStreamed marks a payload of unknown size, framed, with
+	// its length in the trailer.
+	flagStreamed Flags = 1 << 7
+)
+
+// String returns the names of the flags set, separated by "|".
+func (f Flags) String() string {
+	var names []string
+	for i, name := range []string{"compressed", "sealed", "fec"} {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// BoxVersion is the version of the box format written by this package.
+const BoxVersion = 1
+
+// HeaderSize is the size of a box header, in bytes.
+const HeaderSize = 20
+
+var boxMagic = []byte("STEG")
+
+const (
+	// Maximum payload bytes in a frame of a streamed body.
+	frameSize = 32 * 1024
+	// Size of the checksum trailer of a fixed-length body.
+	trailerSize = 4
+	// Size of the checksum and length trailer of a streamed body.
+	streamTrailerSize = 12
+)
+
+// A Header describes a boxed payload.  A box is laid out as the header
+// followed by the body.  All integers are big-endian.
+//
+//	magic      4 bytes, "STEG"
+//	version    1 byte
+//	atom size  1 byte
+//	flags      1 byte
+//	FEC parity 1 byte
+//	length     8 bytes, payload length
+//	checksum   4 bytes, CRC-32 (IEEE) of the above
+//
+// If the payload length is known, the body is the payload followed by
+// its CRC-32.  Otherwise, the body is a sequence of frames, each a
+// 4-byte length and then that many payload bytes, ending with an empty
+// frame, and followed by the payload length in 8 bytes and its CRC-32.
+// The header's length is then zero.
+type Header struct {
+	Version  uint8
+	AtomSize uint8
+	Flags    Flags
+	// Parity bytes per codeword if FlagFEC is set; otherwise 0.
+	FECParity uint8
+	// Payload length in bytes, or -1 if streamed.
+	Length int64
+}
+
+// A Boxer reads a payload and returns it boxed.  Implements io.Reader.
+type Boxer struct {
+	h       *Header
+	payload io.Reader
+	crc     hash.Hash32
+	// Payload bytes boxed so far.
+	n int64
+	// Boxed bytes not yet returned.
+	buf   []byte
+	frame []byte
+	done  bool
+}
+
+// An Unboxer reads a box body and returns its payload, verifying its
+// integrity.  Implements io.Reader.
+type Unboxer struct {
+	h   *Header
+	src io.Reader
+	crc hash.Hash32
+	// Payload bytes unboxed so far.
+	n int64
+	// Remaining payload bytes in the current frame, or in the whole
+	// body if not streamed.
+	rem int64
+	err error
+}
+
+// Marshal returns the header in its binary form.
+func (h *Header) Marshal() []byte {
+	p := make([]byte, HeaderSize)
+	copy(p, boxMagic)
+	p[4] = h.Version
+	p[5] = h.AtomSize
+	flags := h.Flags
+	length := h.Length
+	if length == -1 {
+		flags |= flagStreamed
+		length = 0
+	}
+	p[6] = byte(flags)
+	p[7] = h.FECParity
+	binary.BigEndian.PutUint64(p[8:16], uint64(length))
+	binary.BigEndian.PutUint32(p[16:20], crc32.ChecksumIEEE(p[:16]))
+	return p
+}
+
+// ParseHeader parses the binary form of a header.  Returns ErrBadHeader
+// if p doesn't hold a valid header of a known version.
+func ParseHeader(p []byte) (*Header, error) {
+	if len(p) < HeaderSize || !bytes.Equal(p[:4], boxMagic) {
+		return nil, ErrBadHeader
+	}
+	if binary.BigEndian.Uint32(p[16:20]) != crc32.ChecksumIEEE(p[:16]) {
+		return nil, ErrBadHeader
+	}
+	h := &Header{
+		Version:   p[4],
+		AtomSize:  p[5],
+		Flags:     Flags(p[6]) &^ flagStreamed,
+		FECParity: p[7],
+		Length:    int64(binary.BigEndian.Uint64(p[8:16])),
+	}
+	if h.Version != BoxVersion || h.Length < 0 {
+		return nil, ErrBadHeader
+	}
+	if Flags(p[6])&flagStreamed != 0 {
+		h.Length = -1
+	}
+	return h, nil
+}
+
+// ReadHeader reads and parses a header from r.
+func ReadHeader(r io.Reader) (*Header, error) {
+	p := make([]byte, HeaderSize)
+	_, err := io.ReadFull(r, p)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrBadHeader
+		}
+		return nil, err
+	}
+	return ParseHeader(p)
+}
+
+// BodySize returns the size of the body for the header's payload
+// length, or -1 if it's streamed.
+func (h *Header) BodySize() int64 {
+	if h.Length == -1 {
+		return -1
+	}
+	return h.Length + trailerSize
+}
+
+// BoxedSize returns the size of the entire box for the header's payload
+// length, or -1 if it's streamed.
+func (h *Header) BoxedSize() int64 {
+	if h.Length == -1 {
+		return -1
+	}
+	return HeaderSize + h.BodySize()
+}
+
+// NewBoxer returns a Boxer, ready to box the payload, header and body.
+// If the header's length is -1, the payload is streamed until EOF;
+// otherwise, exactly that many bytes are read from it.
+func NewBoxer(payload io.Reader, h *Header) *Boxer {
+	b := NewBodyBoxer(payload, h)
+	b.buf = h.Marshal()
+	return b
+}
+
+// NewBodyBoxer returns a Boxer, ready to box just the body of the
+// payload, without the header.  Use it to encode the body separately;
+// see FlagFEC.
+func NewBodyBoxer(payload io.Reader, h *Header) *Boxer {
+	b := &Boxer{h: h, payload: payload, crc: crc32.NewIEEE()}
+	if h.Length == -1 {
+		b.frame = make([]byte, frameSize)
+	}
+	return b
+}
+
+// fill prepares the next boxed bytes in b.buf.
+func (b *Boxer) fill() error {
+	if b.h.Length != -1 {
+		if b.n == b.h.Length {
+			b.buf = make([]byte, trailerSize)
+			binary.BigEndian.PutUint32(b.buf, b.crc.Sum32())
+			b.done = true
+			return nil
+		}
+		p := make([]byte, frameSize)
+		if rem := b.h.Length - b.n; rem < frameSize {
+			p = p[:rem]
+		}
+		n, err := b.payload.Read(p)
+		if err == io.EOF && n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		b.crc.Write(p[:n])
+		b.n += int64(n)
+		b.buf = p[:n]
+		return nil
+	}
+
+	n, err := io.ReadFull(b.payload, b.frame)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	b.crc.Write(b.frame[:n])
+	b.n += int64(n)
+	b.buf = make([]byte, 0, 4+n+4+streamTrailerSize)
+	b.buf = binary.BigEndian.AppendUint32(b.buf, uint32(n))
+	b.buf = append(b.buf, b.frame[:n]...)
+	if n == len(b.frame) {
+		return nil
+	}
+	// The payload is exhausted.  Terminate, if we haven't just
+	// done so with an empty frame, and then add the trailer.
+	if n != 0 {
+		b.buf = binary.BigEndian.AppendUint32(b.buf, 0)
+	}
+	b.buf = binary.BigEndian.AppendUint64(b.buf, uint64(b.n))
+	b.buf = binary.BigEndian.AppendUint32(b.buf, b.crc.Sum32())
+	b.done = true
+	return nil
+}
+
+// Read reads boxed bytes.  Returns io.ErrUnexpectedEOF if the payload
+// ends before the header's length.
+func (b *Boxer) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(b.buf) == 0 {
+			if b.done {
+				return n, io.EOF
+			}
+			err = b.fill()
+			if err != nil {
+				return n, err
+			}
+		}
+		nn := copy(p[n:], b.buf)
+		b.buf = b.buf[nn:]
+		n += nn
+	}
+	return n, nil
+}
+
+// NewUnboxer reads a header from the source and returns an Unboxer,
+// ready to read the payload of the body that follows it.
+func NewUnboxer(src io.Reader) (*Unboxer, error) {
+	h, err := ReadHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	return NewBodyUnboxer(src, h), nil
+}
+
+// NewBodyUnboxer returns an Unboxer, ready to read the payload of a
+// body described by the header from the source.
+func NewBodyUnboxer(src io.Reader, h *Header) *Unboxer {
+	u := &Unboxer{h: h, src: src, crc: crc32.NewIEEE()}
+	if h.Length != -1 {
+		u.rem = h.Length
+	}
+	return u
+}
+
+// Header returns the header of the box.
+func (u *Unboxer) Header() *Header {
+	return u.h
+}
+
+// readUint reads a big-endian integer of n bytes.
+func (u *Unboxer) readUint(n int) (uint64, error) {
+	p := make([]byte, 8)
+	_, err := io.ReadFull(u.src, p[8-n:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return binary.BigEndian.Uint64(p), err
+}
+
+// finish reads and verifies the trailer.
+func (u *Unboxer) finish() error {
+	if u.h.Length == -1 {
+		n, err := u.readUint(8)
+		if err != nil {
+			return err
+		}
+		if int64(n) != u.n {
+			return ErrChecksum
+		}
+	}
+	crc, err := u.readUint(4)
+	if err != nil {
+		return err
+	}
+	if uint32(crc) != u.crc.Sum32() {
+		return ErrChecksum
+	}
+	return io.EOF
+}
+
+// Read reads payload bytes.  Returns io.EOF at the end of the payload,
+// without reading beyond the body in the source, once the payload has
+// been verified.  Returns ErrChecksum instead if it's corrupt.
+//
+// Can return io.ErrUnexpectedEOF if the source ends before the body.
+func (u *Unboxer) Read(p []byte) (n int, err error) {
+	for n < len(p) && u.err == nil {
+		if u.rem == 0 {
+			if u.h.Length != -1 {
+				u.err = u.finish()
+				break
+			}
+			size, err := u.readUint(4)
+			if err != nil {
+				u.err = err
+				break
+			}
+			if size == 0 {
+				u.err = u.finish()
+				break
+			}
+			if size > frameSize {
+				u.err = ErrChecksum
+				break
+			}
+			u.rem = int64(size)
+		}
+		q := p[n:]
+		if int64(len(q)) > u.rem {
+			q = q[:u.rem]
+		}
+		nn, err := u.src.Read(q)
+		u.crc.Write(q[:nn])
+		u.n += int64(nn)
+		u.rem -= int64(nn)
+		n += nn
+		if err == io.EOF && u.rem != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			u.err = err
+		}
+	}
+	if n > 0 && u.err == io.EOF {
+		return n, nil
+	}
+	return n, u.err
+}
@@ -0,0 +1,133 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"io/ioutil"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testBox(t *testing.T, size int, streamed bool) {
+	payload := make([]byte, size)
+	if _, err := cryptorand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	h := &Header{Version: BoxVersion, AtomSize: 2, Flags: FlagSealed, Length: int64(size)}
+	if streamed {
+		h.Length = -1
+	}
+	boxed, err := ioutil.ReadAll(NewBoxer(bytes.NewReader(payload), h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !streamed && int64(len(boxed)) != h.BoxedSize() {
+		t.Errorf("boxed size %v (expected %v)", len(boxed), h.BoxedSize())
+	}
+
+	// Trailing data should be left alone.
+	src := bytes.NewReader(append(boxed, "trailing"...))
+	u, err := NewUnboxer(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *u.Header() != *h {
+		t.Errorf("header %+v (expected %+v)", *u.Header(), *h)
+	}
+	test, err := ioutil.ReadAll(u)
+	if err != nil {
+		t.Fatalf("unbox error %v, size = %v, streamed = %v", err, size, streamed)
+	}
+	if !bytes.Equal(test, payload) {
+		t.Errorf("failed to unbox %v bytes, streamed = %v", size, streamed)
+	}
+	if src.Len() != len("trailing") {
+		t.Errorf("read %v bytes past box", len("trailing")-src.Len())
+	}
+
+	// Corrupt a payload byte.
+	if size == 0 {
+		return
+	}
+	boxed[HeaderSize+4+mathrand.Intn(size)] ^= 0x01
+	u, err = NewUnboxer(bytes.NewReader(boxed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(u); err != ErrChecksum {
+		t.Errorf("corrupt payload: %v (expected %v)", err, ErrChecksum)
+	}
+}
+
+func TestBox(t *testing.T) {
+	for _, streamed := range []bool{false, true} {
+		testBox(t, 0, streamed)
+		testBox(t, 5, streamed)
+		testBox(t, frameSize, streamed)
+		testBox(t, 2*frameSize+17, streamed)
+		for i := 0; i < 10; i++ {
+			testBox(t, mathrand.Intn(3*frameSize), streamed)
+		}
+	}
+}
+
+func TestBoxShortPayload(t *testing.T) {
+	h := &Header{Version: BoxVersion, AtomSize: 1, Length: 10}
+	_, err := ioutil.ReadAll(NewBoxer(bytes.NewReader(make([]byte, 9)), h))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v (expected %v)", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	h := &Header{Version: BoxVersion, AtomSize: 3, Flags: FlagFEC, FECParity: 16, Length: 12345}
+	p := h.Marshal()
+	hh, err := ParseHeader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *hh != *h {
+		t.Errorf("parsed %+v (expected %+v)", *hh, *h)
+	}
+	for i := 0; i < HeaderSize; i++ {
+		q := append([]byte(nil), p...)
+		q[i] ^= 0x10
+		if _, err := ParseHeader(q); err != ErrBadHeader {
+			t.Errorf("corrupt byte %v: %v (expected %v)", i, err, ErrBadHeader)
+		}
+	}
+	if _, err := ParseHeader(p[:HeaderSize-1]); err != ErrBadHeader {
+		t.Errorf("short header: %v (expected %v)", err, ErrBadHeader)
+	}
+}
+
+func TestBoxMux(t *testing.T) {
+	ctx := NewCtx(1)
+	payload := []byte("boxed secret")
+	h := &Header{Version: BoxVersion, AtomSize: 1, Length: -1}
+	carrierBytes := make([]byte, 100*ctx.chunkSize)
+	if _, err := cryptorand.Read(carrierBytes); err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrierBytes), NewBoxer(bytes.NewReader(payload), h))
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	u, err := NewUnboxer(ctx.NewReader(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	test, err := ioutil.ReadAll(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, payload) {
+		t.Errorf("failed to unbox %#v (got %#v)", payload, test)
+	}
+}
@@ -0,0 +1,53 @@
+// chris 101826
+
+package carrier
+
+// A carrierBit is a single carrier bit of a decoded carrier: the bits
+// of mask in the byte at p, all set or all clear, inverted if inv.
+type carrierBit struct {
+	p    *byte
+	mask byte
+	inv  bool
+}
+
+// bitSamples exposes carrier bits scattered through a decoded carrier,
+// for carriers whose bytes can't be exposed whole.
+type bitSamples struct {
+	bits    []carrierBit
+	samples []byte
+}
+
+// add appends a carrier bit.
+func (b *bitSamples) add(p *byte, mask byte, inv bool) {
+	b.bits = append(b.bits, carrierBit{p, mask, inv})
+}
+
+// pack packs the carrier bits added into samples, dropping any left
+// over at the end.
+func (b *bitSamples) pack() {
+	b.samples = make([]byte, len(b.bits)/8)
+	b.bits = b.bits[:len(b.samples)*8]
+	for i, cb := range b.bits {
+		if (*cb.p&cb.mask != 0) != cb.inv {
+			b.samples[i>>3] |= 1 << uint(i&7)
+		}
+	}
+}
+
+// unpack sets the carrier bits to those of the samples.
+func (b *bitSamples) unpack() {
+	for i, cb := range b.bits {
+		if (b.samples[i>>3]>>uint(i&7)&1 == 1) != cb.inv {
+			*cb.p |= cb.mask
+		} else {
+			*cb.p &^= cb.mask
+		}
+	}
+}
+
+// Samples returns the carrier bits, packed eight to a sample byte,
+// least-significant bit first.  Any left over at the end, too few to
+// make a whole sample, are never exposed.
+func (b *bitSamples) Samples() []byte {
+	return b.samples
+}
@@ -0,0 +1,115 @@
+// chris 101826
+
+package carrier
+
+import (
+	"image"
+	"io"
+
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// BMP compression methods.
+const (
+	bmpRGB       = 0
+	bmpBitfields = 3
+)
+
+// BMP is a Carrier for uncompressed 24- and 32-bit BMP images.  Its
+// samples are the blue, green, and red bytes of each pixel, rows from
+// the top of the image down, whichever way the file stores them.  Row
+// padding and the fourth byte of 32-bit pixels, which may be alpha, are
+// never exposed.  All other bytes of the file are left untouched, so
+// the encoded file has the same headers and length as the original.
+//
+// Paletted and 16-bit images are unsupported, since changing an index
+// or a packed pixel's low bit can change its color arbitrarily.
+type BMP struct {
+	data    []byte
+	layout  *layout
+	samples []byte
+}
+
+// DecodeBMP decodes a BMP image from r, ready for embedding.  Can
+// return ErrUnsupported for compressed, paletted, or 16-bit images.
+func DecodeBMP(r io.Reader) (*BMP, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 26 || string(data[0:2]) != "BM" {
+		return nil, ErrMalformed
+	}
+	off := int64(binary.LittleEndian.Uint32(data[10:14]))
+	hsize := binary.LittleEndian.Uint32(data[14:18])
+	var width, height int64
+	var bpp, compression uint32
+	switch {
+	case hsize == 12:
+		// BITMAPCOREHEADER.
+		width = int64(binary.LittleEndian.Uint16(data[18:20]))
+		height = int64(binary.LittleEndian.Uint16(data[20:22]))
+		bpp = uint32(binary.LittleEndian.Uint16(data[24:26]))
+	case hsize >= 40 && len(data) >= 54:
+		// BITMAPINFOHEADER, or a later extension of it.
+		width = int64(int32(binary.LittleEndian.Uint32(data[18:22])))
+		height = int64(int32(binary.LittleEndian.Uint32(data[22:26])))
+		bpp = uint32(binary.LittleEndian.Uint16(data[28:30]))
+		compression = binary.LittleEndian.Uint32(data[30:34])
+	default:
+		return nil, ErrMalformed
+	}
+	bottomUp := height > 0
+	if height < 0 {
+		height = -height
+	}
+	if width <= 0 || height == 0 {
+		return nil, ErrMalformed
+	}
+	switch {
+	case bpp == 24 && compression == bmpRGB:
+	case bpp == 32 && compression == bmpRGB:
+	case bpp == 32 && compression == bmpBitfields:
+		// Only the usual masks put blue, green, and red in
+		// the first three bytes.  They follow the fields of
+		// BITMAPINFOHEADER, after it or within a later header.
+		masks := data[14+40:]
+		if len(masks) < 12 ||
+			binary.LittleEndian.Uint32(masks[0:4]) != 0x00ff0000 ||
+			binary.LittleEndian.Uint32(masks[4:8]) != 0x0000ff00 ||
+			binary.LittleEndian.Uint32(masks[8:12]) != 0x000000ff {
+			return nil, ErrUnsupported
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+	size := int64(bpp / 8)
+	// Rows are padded to a multiple of 4 bytes.
+	stride := (width*size + 3) / 4 * 4
+	// Divide, since stride*height can overflow.
+	if off > int64(len(data)) || height > (int64(len(data))-off)/stride {
+		return nil, ErrMalformed
+	}
+	l := &layout{
+		pix:      data[off : off+stride*height],
+		stride:   int(stride),
+		rect:     image.Rect(0, 0, int(width), int(height)),
+		size:     int(size),
+		offsets:  []int{0, 1, 2},
+		bottomUp: bottomUp,
+	}
+	return &BMP{data: data, layout: l, samples: l.gather()}, nil
+}
+
+// Samples returns the color bytes of the image's pixels.
+func (b *BMP) Samples() []byte {
+	return b.samples
+}
+
+// Encode writes the image, with its current samples, to w.
+func (b *BMP) Encode(w io.Writer) error {
+	b.layout.scatter(b.samples)
+	_, err := w.Write(b.data)
+	return err
+}
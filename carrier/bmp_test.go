@@ -0,0 +1,113 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"testing"
+
+	"crypto/rand"
+	"encoding/binary"
+
+	"chrispennello.com/go/steg"
+)
+
+// testBMPBytes returns a BMP file with a BITMAPINFOHEADER and random
+// pixels, along with the offset of the pixel array.  A negative height
+// makes the rows top-down.
+func testBMPBytes(t *testing.T, width, height, bpp int, compression uint32) ([]byte, int) {
+	rows := height
+	if rows < 0 {
+		rows = -rows
+	}
+	stride := (width*bpp/8 + 3) / 4 * 4
+	off := 14 + 40
+	if compression == bmpBitfields {
+		off += 12
+	}
+	pix := make([]byte, stride*rows)
+	if _, err := rand.Read(pix); err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString("BM")
+	binary.Write(b, binary.LittleEndian, uint32(off+len(pix)))
+	binary.Write(b, binary.LittleEndian, uint32(0))
+	binary.Write(b, binary.LittleEndian, uint32(off))
+	binary.Write(b, binary.LittleEndian, uint32(40))
+	binary.Write(b, binary.LittleEndian, int32(width))
+	binary.Write(b, binary.LittleEndian, int32(height))
+	binary.Write(b, binary.LittleEndian, uint16(1))
+	binary.Write(b, binary.LittleEndian, uint16(bpp))
+	binary.Write(b, binary.LittleEndian, compression)
+	binary.Write(b, binary.LittleEndian, uint32(len(pix)))
+	binary.Write(b, binary.LittleEndian, [4]uint32{2835, 2835, 0, 0})
+	if compression == bmpBitfields {
+		binary.Write(b, binary.LittleEndian, [3]uint32{0x00ff0000, 0x0000ff00, 0x000000ff})
+	}
+	b.Write(pix)
+	return b.Bytes(), off
+}
+
+func testBMP(t *testing.T, width, height, bpp int, compression uint32) {
+	ctx := steg.NewPlaneCtx(1, 0x01)
+	src, off := testBMPBytes(t, width, height, bpp, compression)
+	size := bpp / 8
+	stride := (width*size + 3) / 4 * 4
+	rows := height
+	if rows < 0 {
+		rows = -rows
+	}
+
+	testEncodeUnchanged(t, src, decoders["bmp"])
+	c, out := testRoundTrip(t, ctx, src, decoders["bmp"])
+	if len(c.Samples()) != width*rows*3 {
+		t.Fatalf("%v samples (expected %v)", len(c.Samples()), width*rows*3)
+	}
+	// The first samples are of the top row.
+	top := off
+	if height > 0 {
+		top += (rows - 1) * stride
+	}
+	if !bytes.Equal(c.Samples()[:3], out[top:top+3]) {
+		t.Errorf("first samples %#v aren't of the top-left pixel %#v", c.Samples()[:3], out[top:top+3])
+	}
+	if len(out) != len(src) {
+		t.Fatalf("length changed from %v to %v", len(src), len(out))
+	}
+	for i := range src {
+		if src[i] == out[i] {
+			continue
+		}
+		if i < off || (i-off)%stride >= width*size || (i-off)%stride%size >= 3 {
+			t.Fatalf("byte %v outside of pixel colors changed", i)
+		}
+	}
+}
+
+func TestBMP(t *testing.T) {
+	// Odd widths have row padding.
+	testBMP(t, 33, 17, 24, bmpRGB)
+	testBMP(t, 33, -17, 24, bmpRGB)
+	testBMP(t, 20, 9, 32, bmpRGB)
+	testBMP(t, 21, -9, 32, bmpBitfields)
+}
+
+func TestBMPUnsupported(t *testing.T) {
+	src, _ := testBMPBytes(t, 8, 8, 8, bmpRGB)
+	if _, err := DecodeBMP(bytes.NewReader(src)); err != ErrUnsupported {
+		t.Errorf("paletted: %v (expected %v)", err, ErrUnsupported)
+	}
+	src, _ = testBMPBytes(t, 8, 8, 24, bmpRGB)
+	if _, err := DecodeBMP(bytes.NewReader(src[:len(src)-1])); err != ErrMalformed {
+		t.Errorf("truncated: %v (expected %v)", err, ErrMalformed)
+	}
+	// Pixels that would take more than 2^63 bytes.
+	src, _ = testBMPBytes(t, 8, 8, 32, bmpRGB)
+	binary.LittleEndian.PutUint32(src[18:22], 0x7fffffff)
+	binary.LittleEndian.PutUint32(src[22:26], 0x80000000)
+	if _, err := DecodeBMP(bytes.NewReader(src)); err != ErrMalformed {
+		t.Errorf("huge: %v (expected %v)", err, ErrMalformed)
+	}
+}
@@ -0,0 +1,92 @@
+// chris 101826
+
+// Package carrier implements adapters for structured carrier formats.
+//
+// Package steg deliberately makes no effort to be aware of the
+// character of the carrier data, and so embedding directly into, say, a
+// compressed image will corrupt it.  An adapter decodes such a carrier
+// and exposes only the bytes that can safely be modified, its samples,
+// as the carrier stream for a steg.Mux or steg.Reader.  After
+// embedding, the adapter reassembles a valid file around the modified
+// samples.
+package carrier
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"chrispennello.com/go/steg"
+)
+
+// A Carrier is a decoded structured carrier.
+type Carrier interface {
+	// Samples returns the bytes of the carrier available for
+	// embedding.  Modifications made to them are reflected in the
+	// output of Encode.
+	Samples() []byte
+	// Encode writes the carrier, with its current samples, to w in
+	// its original format.
+	Encode(w io.Writer) error
+}
+
+// ErrUnknownFormat is returned by Decode for an unrecognized format
+// name.
+var ErrUnknownFormat = errors.New("unknown carrier format")
+
+var decoders = map[string]func(io.Reader) (Carrier, error){
+	"bmp":     func(r io.Reader) (Carrier, error) { return DecodeBMP(r) },
+	"gif":     func(r io.Reader) (Carrier, error) { return DecodeGIF(r) },
+	"jpeg":    func(r io.Reader) (Carrier, error) { return DecodeJPEG(r) },
+	"pdf":     func(r io.Reader) (Carrier, error) { return DecodePDF(r) },
+	"png":     func(r io.Reader) (Carrier, error) { return DecodePNG(r) },
+	"pnm":     func(r io.Reader) (Carrier, error) { return DecodePNM(r) },
+	"tar":     func(r io.Reader) (Carrier, error) { return DecodeTAR(r) },
+	"text":    func(r io.Reader) (Carrier, error) { return DecodeText(r, false) },
+	"text+zw": func(r io.Reader) (Carrier, error) { return DecodeText(r, true) },
+	"wav":     func(r io.Reader) (Carrier, error) { return DecodeWAV(r) },
+	"zip":     func(r io.Reader) (Carrier, error) { return DecodeZIP(r) },
+}
+
+// Decode decodes a carrier of the named format from r.  The recognized
+// formats are "bmp", "gif", "jpeg", "pdf", "png", "pnm", "tar", "text",
+// "text+zw", "wav", and "zip", where "text+zw" is text whose zero-width
+// spaces after words are carrier bits as well.
+func Decode(format string, r io.Reader) (Carrier, error) {
+	decode, ok := decoders[format]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+	return decode(r)
+}
+
+// Capacity returns the largest message the carrier can embed using the
+// given context, in bytes.
+func Capacity(ctx *steg.Ctx, c Carrier) int64 {
+	return ctx.Capacity(int64(len(c.Samples())))
+}
+
+// Mux steganographically embeds the message into the samples of the
+// carrier, and then encodes the modified carrier into dst.  The
+// embedding semantics, including padding of a final partial atom, are
+// those of steg.Mux.
+//
+// Can return steg.ErrShortCarrier if the carrier's samples are too
+// small for the message, in which case nothing will have been written
+// to dst.
+func Mux(ctx *steg.Ctx, dst io.Writer, c Carrier, msg io.Reader) error {
+	samples := c.Samples()
+	buf := bytes.NewBuffer(make([]byte, 0, len(samples)))
+	err := ctx.NewMux(buf, bytes.NewReader(samples), msg).Mux()
+	if err != nil {
+		return err
+	}
+	copy(samples, buf.Bytes())
+	return c.Encode(dst)
+}
+
+// NewReader returns a steg.Reader, ready to read
+// steganographically-embedded bytes out of the samples of the carrier.
+func NewReader(ctx *steg.Ctx, c Carrier) *steg.Reader {
+	return ctx.NewReader(bytes.NewReader(c.Samples()))
+}
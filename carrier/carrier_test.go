@@ -0,0 +1,65 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"crypto/rand"
+
+	"chrispennello.com/go/steg"
+)
+
+// testRoundTrip decodes src with decode, embeds a random message as big
+// as the carrier can hold, and checks that the message can be extracted
+// again from the decoded output.  Returns the carrier, after embedding,
+// and the output, for format-specific checks.
+func testRoundTrip(t *testing.T, ctx *steg.Ctx, src []byte, decode func(io.Reader) (Carrier, error)) (Carrier, []byte) {
+	c, err := decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, Capacity(ctx, c))
+	if len(msg) == 0 {
+		t.Fatal("no capacity")
+	}
+	if _, err := rand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	if err := Mux(ctx, dst, c, bytes.NewReader(msg)); err != nil {
+		t.Fatal(err)
+	}
+	out := dst.Bytes()
+
+	c2, err := decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := make([]byte, len(msg))
+	if _, err := io.ReadFull(NewReader(ctx, c2), test); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to extract %#v (got %#v)", msg, test)
+	}
+	return c, out
+}
+
+// testEncodeUnchanged checks that encoding the carrier decoded from src
+// without changing its samples reproduces src.
+func testEncodeUnchanged(t *testing.T, src []byte, decode func(io.Reader) (Carrier, error)) {
+	c, err := decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	if err := c.Encode(dst); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), src) {
+		t.Error("unchanged carrier encoded differently")
+	}
+}
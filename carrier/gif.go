@@ -0,0 +1,117 @@
+// chris 101826
+
+package carrier
+
+import (
+	"io"
+	"sort"
+
+	"image/color"
+	"image/gif"
+)
+
+// GIF is a Carrier for GIF images, including animated ones.  Its
+// carrier bits are the least-significant bits of the palette indices of
+// the pixels of each frame in turn, in row-major order.
+//
+// Each palette is sorted by luminance, and the pixels remapped to
+// match, so that flipping the least-significant bit of an index
+// usually picks a similar color.  Transparent entries sort last, and
+// pixels whose index could flip to or from one, or to past the end of
+// the palette, are never exposed.  Sorting a sorted palette changes
+// nothing, so the carrier bits of an encoded image are where they were
+// in the original.
+//
+// The image is reencoded, so extensions other than animation and
+// transparency, such as comments, are lost.
+type GIF struct {
+	bitSamples
+	g *gif.GIF
+}
+
+// luminance returns the luma of a color, ignoring alpha.
+func luminance(c color.Color) uint32 {
+	r, g, b, _ := c.RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+// sortPalette returns a sorted copy of p, transparent entries last, and
+// the new index of each of p's entries.
+func sortPalette(p color.Palette) (color.Palette, []uint8) {
+	order := make([]int, len(p))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		_, _, _, ai := p[order[i]].RGBA()
+		_, _, _, aj := p[order[j]].RGBA()
+		if (ai == 0) != (aj == 0) {
+			return aj == 0
+		}
+		return luminance(p[order[i]]) < luminance(p[order[j]])
+	})
+	sorted := make(color.Palette, len(p))
+	perm := make([]uint8, len(p))
+	for i, o := range order {
+		sorted[i] = p[o]
+		perm[o] = uint8(i)
+	}
+	return sorted, perm
+}
+
+// DecodeGIF decodes a GIF image from r, ready for embedding.
+func DecodeGIF(r io.Reader) (*GIF, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := g.Config.ColorModel.(color.Palette); ok {
+		var perm []uint8
+		g.Config.ColorModel, perm = sortPalette(p)
+		if int(g.BackgroundIndex) < len(perm) {
+			g.BackgroundIndex = perm[g.BackgroundIndex]
+		}
+	}
+
+	c := &GIF{g: g}
+	for _, m := range g.Image {
+		var perm []uint8
+		m.Palette, perm = sortPalette(m.Palette)
+		// Whether each pair of indices differing only in their
+		// least-significant bits are both opaque colors.
+		opaque := make([]bool, (len(m.Palette)+1)/2)
+		for i := range opaque {
+			opaque[i] = 2*i+1 < len(m.Palette)
+			for _, k := range []int{2 * i, 2*i + 1} {
+				if k >= len(m.Palette) {
+					break
+				}
+				if _, _, _, a := m.Palette[k].RGBA(); a == 0 {
+					opaque[i] = false
+				}
+			}
+		}
+		b := m.Rect
+		for y := 0; y < b.Dy(); y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+b.Dx()]
+			for x := range row {
+				if int(row[x]) >= len(perm) {
+					return nil, ErrMalformed
+				}
+				row[x] = perm[row[x]]
+				if opaque[row[x]>>1] {
+					c.add(&row[x], 1, false)
+				}
+			}
+		}
+	}
+
+	c.pack()
+	return c, nil
+}
+
+// Encode writes the image, with its current carrier bits, to w.
+func (c *GIF) Encode(w io.Writer) error {
+	c.unpack()
+	return gif.EncodeAll(w, c.g)
+}
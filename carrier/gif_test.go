@@ -0,0 +1,106 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"crypto/rand"
+	"image/color"
+	"image/gif"
+
+	"chrispennello.com/go/steg"
+)
+
+// testGIFBytes returns an animated GIF of random pixels with a global
+// palette of random colors, whose second frame has a transparent color
+// and a local palette.
+func testGIFBytes(t *testing.T, width, height int) []byte {
+	rgb := make([]byte, 3*32)
+	if _, err := rand.Read(rgb); err != nil {
+		t.Fatal(err)
+	}
+	global := make(color.Palette, 16)
+	local := make(color.Palette, 16)
+	for i := range global {
+		global[i] = color.RGBA{rgb[3*i], rgb[3*i+1], rgb[3*i+2], 0xff}
+		local[i] = color.RGBA{rgb[48+3*i], rgb[48+3*i+1], rgb[48+3*i+2], 0xff}
+	}
+	local[5] = color.RGBA{}
+
+	g := &gif.GIF{
+		Config: image.Config{ColorModel: global, Width: width, Height: height},
+	}
+	for _, p := range []color.Palette{global, local} {
+		m := image.NewPaletted(image.Rect(0, 0, width, height), p)
+		if _, err := rand.Read(m.Pix); err != nil {
+			t.Fatal(err)
+		}
+		for i := range m.Pix {
+			m.Pix[i] %= uint8(len(p))
+		}
+		g.Image = append(g.Image, m)
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	b := new(bytes.Buffer)
+	if err := gif.EncodeAll(b, g); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestGIF(t *testing.T) {
+	ctx := steg.NewPlaneCtx(1, 0xff)
+	src := testGIFBytes(t, 29, 13)
+
+	c, err := DecodeGIF(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pixels of the transparent color and its neighbor aren't
+	// exposed.
+	if n := len(c.Samples()); n == 0 || n > 2*29*13/8 {
+		t.Fatalf("%v samples", n)
+	}
+	for _, m := range c.g.Image {
+		var last uint32
+		for i, col := range m.Palette {
+			if _, _, _, a := col.RGBA(); a == 0 {
+				if i != len(m.Palette)-1 {
+					t.Errorf("transparent color at %v of %v", i, len(m.Palette))
+				}
+				continue
+			}
+			if l := luminance(col); l < last {
+				t.Errorf("palette unsorted at %v", i)
+			} else {
+				last = l
+			}
+		}
+	}
+	orig := make([][]byte, len(c.g.Image))
+	for i, m := range c.g.Image {
+		orig[i] = append([]byte(nil), m.Pix...)
+	}
+
+	mc, out := testRoundTrip(t, ctx, src, decoders["gif"])
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 {
+		t.Fatalf("%v frames (expected 2)", len(g.Image))
+	}
+	// Only the least-significant bits of opaque pixels' indices
+	// changed.
+	for i, m := range mc.(*GIF).g.Image {
+		for k := range m.Pix {
+			if d := m.Pix[k] ^ orig[i][k]; d > 1 || d == 1 && m.Pix[k]>>1 == 7 && i == 1 {
+				t.Fatalf("frame %v pixel %v changed from %v to %v", i, k, orig[i][k], m.Pix[k])
+			}
+		}
+	}
+}
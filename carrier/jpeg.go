@@ -0,0 +1,433 @@
+// chris 101826
+
+package carrier
+
+import (
+	"io"
+
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// JPEG is a Carrier for baseline JPEG images.  Its carrier bits are the
+// least-significant bits of the magnitudes of the quantized AC DCT
+// coefficients, skipping those of magnitude 0 and 1, in scan order.
+//
+// Flipping such a bit changes a coefficient's magnitude by one, but
+// never to or from 0 or 1, so the Huffman symbols coding the image are
+// unchanged, and only the last of the coefficient's additional bits
+// flips.  Encode therefore flips those bits in the original
+// entropy-coded data, which keeps the original quantization and Huffman
+// tables, markers, and everything else.  The encoded file differs in
+// length from the original by at most the odd byte of stuffing.
+//
+// Progressive, lossless, arithmetic-coded, and 12-bit images are
+// unsupported.
+type JPEG struct {
+	bitSamples
+	data  []byte
+	scans []*jpegScan
+}
+
+// A jpegScan is the entropy-coded data of a scan.
+type jpegScan struct {
+	// Offsets of the data in the file.
+	start, end int
+	// Unstuffed data of each restart interval, and the second byte
+	// of the RST marker following each but the last.
+	intervals [][]byte
+	rst       []byte
+}
+
+// A huffman is a decoding table for a JPEG Huffman table.
+type huffman struct {
+	// Largest code of each length, or -1 if none; the index into
+	// vals of the first code of each length, and that code.
+	maxcode [17]int32
+	valptr  [17]int32
+	mincode [17]int32
+	vals    []byte
+}
+
+// A jpegComponent is an image component, as declared in the frame
+// header.
+type jpegComponent struct {
+	id   byte
+	h, v int
+}
+
+func newHuffman(counts []byte, vals []byte) *huffman {
+	h := &huffman{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		h.valptr[l] = k
+		h.mincode[l] = code
+		code += n
+		k += n
+		h.maxcode[l] = -1
+		if n > 0 {
+			h.maxcode[l] = code - 1
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// jpegBits reads bits, most-significant first, from a restart
+// interval's unstuffed data.
+type jpegBits struct {
+	p   []byte
+	pos int64
+}
+
+func (b *jpegBits) read(n int) (uint32, error) {
+	v := uint32(0)
+	for i := 0; i < n; i++ {
+		if b.pos>>3 >= int64(len(b.p)) {
+			return 0, ErrMalformed
+		}
+		bit := b.p[b.pos>>3] >> (7 - uint(b.pos&7)) & 1
+		v = v<<1 | uint32(bit)
+		b.pos++
+	}
+	return v, nil
+}
+
+func (b *jpegBits) decode(h *huffman) (byte, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		bit, err := b.read(1)
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | int32(bit)
+		if code <= h.maxcode[l] {
+			i := h.valptr[l] + code - h.mincode[l]
+			if int(i) >= len(h.vals) {
+				return 0, ErrMalformed
+			}
+			return h.vals[i], nil
+		}
+	}
+	return 0, ErrMalformed
+}
+
+// parseDHT parses the Huffman tables of a DHT segment into dc and ac.
+func parseDHT(seg []byte, dc, ac *[4]*huffman) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return ErrMalformed
+		}
+		class, id := seg[0]>>4, seg[0]&0xf
+		if class > 1 || id > 3 {
+			return ErrMalformed
+		}
+		counts := seg[1:17]
+		n := 0
+		for _, c := range counts {
+			n += int(c)
+		}
+		if len(seg) < 17+n || n > 256 {
+			return ErrMalformed
+		}
+		h := newHuffman(counts, seg[17:17+n])
+		if class == 0 {
+			dc[id] = h
+		} else {
+			ac[id] = h
+		}
+		seg = seg[17+n:]
+	}
+	return nil
+}
+
+// unstuff splits the entropy-coded data starting at off into restart
+// intervals, returning them along with the RST markers between them,
+// and the offset of the marker ending the data.
+func unstuff(data []byte, off int) (intervals [][]byte, rst []byte, end int) {
+	var cur []byte
+	for off < len(data) {
+		b := data[off]
+		if b != 0xff {
+			cur = append(cur, b)
+			off++
+			continue
+		}
+		if off+1 >= len(data) {
+			break
+		}
+		m := data[off+1]
+		if m == 0x00 {
+			cur = append(cur, 0xff)
+			off += 2
+			continue
+		}
+		if m >= 0xd0 && m <= 0xd7 {
+			intervals = append(intervals, cur)
+			rst = append(rst, m)
+			cur = nil
+			off += 2
+			continue
+		}
+		break
+	}
+	return append(intervals, cur), rst, off
+}
+
+// stuff writes a restart interval's data, stuffing 0xff bytes.
+func stuff(dst []byte, p []byte) []byte {
+	for _, b := range p {
+		dst = append(dst, b)
+		if b == 0xff {
+			dst = append(dst, 0x00)
+		}
+	}
+	return dst
+}
+
+// decodeScan finds the carrier bits of a scan of the given components,
+// in MCU order, with the given Huffman tables and restart interval.
+func (j *JPEG) decodeScan(s *jpegScan, comps []jpegComponent, dc, ac []*huffman, width, height, hmax, vmax, ri int) error {
+	// Blocks per MCU of each component, and the number of MCUs.
+	nblocks := make([]int, len(comps))
+	var nmcus int
+	if len(comps) == 1 {
+		// Non-interleaved: one block per MCU, covering just the
+		// component.
+		cw := (width*comps[0].h + hmax - 1) / hmax
+		ch := (height*comps[0].v + vmax - 1) / vmax
+		nblocks[0] = 1
+		nmcus = ((cw + 7) / 8) * ((ch + 7) / 8)
+	} else {
+		for i, c := range comps {
+			nblocks[i] = c.h * c.v
+		}
+		nmcus = ((width + 8*hmax - 1) / (8 * hmax)) * ((height + 8*vmax - 1) / (8 * vmax))
+	}
+	if ri == 0 {
+		ri = nmcus
+	}
+	if len(s.intervals) < (nmcus+ri-1)/ri {
+		return ErrMalformed
+	}
+
+	var b *jpegBits
+	iv := -1
+	for mcu := 0; mcu < nmcus; mcu++ {
+		if mcu%ri == 0 {
+			iv++
+			b = &jpegBits{p: s.intervals[iv]}
+		}
+		for i := range comps {
+			for n := 0; n < nblocks[i]; n++ {
+				// DC.
+				t, err := b.decode(dc[i])
+				if err != nil {
+					return err
+				}
+				if t > 11 {
+					return ErrMalformed
+				}
+				if _, err = b.read(int(t)); err != nil {
+					return err
+				}
+				// AC.
+				for k := 1; k < 64; k++ {
+					rs, err := b.decode(ac[i])
+					if err != nil {
+						return err
+					}
+					r, size := int(rs>>4), int(rs&0xf)
+					if size == 0 {
+						if r == 15 {
+							k += 15
+							continue
+						}
+						break
+					}
+					k += r
+					if k > 63 {
+						return ErrMalformed
+					}
+					v, err := b.read(size)
+					if err != nil {
+						return err
+					}
+					if size < 2 {
+						// Magnitude 1.
+						continue
+					}
+					// A leading 0 bit means negative, in
+					// which case the magnitude's
+					// least-significant bit is the
+					// complement of the coded bit.
+					neg := v>>uint(size-1) == 0
+					bit := b.pos - 1
+					j.add(&b.p[bit>>3], 0x80>>uint(bit&7), neg)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeJPEG decodes a baseline JPEG image from r, ready for embedding.
+// Can return ErrUnsupported for other kinds of JPEG image.
+func DecodeJPEG(r io.Reader) (*JPEG, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrMalformed
+	}
+	j := &JPEG{data: data}
+	var dc, ac [4]*huffman
+	var comps []jpegComponent
+	var width, height, hmax, vmax, ri int
+	off := 2
+	for {
+		if off+2 > len(data) || data[off] != 0xff {
+			return nil, ErrMalformed
+		}
+		// Markers may be preceded by any number of fill bytes.
+		for off+1 < len(data) && data[off+1] == 0xff {
+			off++
+		}
+		if off+2 > len(data) {
+			return nil, ErrMalformed
+		}
+		m := data[off+1]
+		off += 2
+		if m == 0xd9 {
+			// EOI.
+			break
+		}
+		if m == 0x01 || (m >= 0xd0 && m <= 0xd7) {
+			// No segment.
+			continue
+		}
+		if off+2 > len(data) {
+			return nil, ErrMalformed
+		}
+		n := int(binary.BigEndian.Uint16(data[off:]))
+		if n < 2 || off+n > len(data) {
+			return nil, ErrMalformed
+		}
+		seg := data[off+2 : off+n]
+		off += n
+		switch {
+		case m == 0xc4:
+			// DHT.
+			if err := parseDHT(seg, &dc, &ac); err != nil {
+				return nil, err
+			}
+		case m == 0xc0 || m == 0xc1:
+			// SOF0 and SOF1: baseline and extended sequential
+			// Huffman.
+			if len(seg) < 6 || seg[0] != 8 {
+				return nil, ErrUnsupported
+			}
+			height = int(binary.BigEndian.Uint16(seg[1:3]))
+			width = int(binary.BigEndian.Uint16(seg[3:5]))
+			nc := int(seg[5])
+			if width == 0 || height == 0 || nc == 0 || len(seg) < 6+3*nc {
+				return nil, ErrMalformed
+			}
+			comps = make([]jpegComponent, nc)
+			for i := range comps {
+				p := seg[6+3*i:]
+				c := jpegComponent{id: p[0], h: int(p[1] >> 4), v: int(p[1] & 0xf)}
+				if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 {
+					return nil, ErrMalformed
+				}
+				if c.h > hmax {
+					hmax = c.h
+				}
+				if c.v > vmax {
+					vmax = c.v
+				}
+				comps[i] = c
+			}
+		case m >= 0xc2 && m <= 0xcf && m != 0xc4 && m != 0xc8 && m != 0xcc:
+			// Other SOFs.
+			return nil, ErrUnsupported
+		case m == 0xdd:
+			// DRI.
+			if len(seg) < 2 {
+				return nil, ErrMalformed
+			}
+			ri = int(binary.BigEndian.Uint16(seg))
+		case m == 0xda:
+			// SOS.
+			if comps == nil || len(seg) < 1 {
+				return nil, ErrMalformed
+			}
+			ns := int(seg[0])
+			if ns < 1 || len(seg) < 1+2*ns+3 {
+				return nil, ErrMalformed
+			}
+			scomps := make([]jpegComponent, ns)
+			sdc := make([]*huffman, ns)
+			sac := make([]*huffman, ns)
+			for i := 0; i < ns; i++ {
+				id, t := seg[1+2*i], seg[2+2*i]
+				found := false
+				for _, c := range comps {
+					if c.id == id {
+						scomps[i], found = c, true
+					}
+				}
+				if !found || t>>4 > 3 || t&0xf > 3 {
+					return nil, ErrMalformed
+				}
+				sdc[i], sac[i] = dc[t>>4], ac[t&0xf]
+				if sdc[i] == nil || sac[i] == nil {
+					return nil, ErrMalformed
+				}
+			}
+			p := seg[1+2*ns:]
+			if p[0] != 0 || p[1] != 63 || p[2] != 0 {
+				// Spectral selection or successive
+				// approximation.
+				return nil, ErrUnsupported
+			}
+			s := &jpegScan{start: off}
+			s.intervals, s.rst, s.end = unstuff(data, off)
+			err := j.decodeScan(s, scomps, sdc, sac, width, height, hmax, vmax, ri)
+			if err != nil {
+				return nil, err
+			}
+			j.scans = append(j.scans, s)
+			off = s.end
+		}
+	}
+	if j.scans == nil {
+		return nil, ErrMalformed
+	}
+
+	j.pack()
+	return j, nil
+}
+
+// Encode writes the image, with its current carrier bits, to w.
+func (j *JPEG) Encode(w io.Writer) error {
+	j.unpack()
+
+	out := make([]byte, 0, len(j.data))
+	prev := 0
+	for _, s := range j.scans {
+		out = append(out, j.data[prev:s.start]...)
+		for i, p := range s.intervals {
+			out = stuff(out, p)
+			if i < len(s.rst) {
+				out = append(out, 0xff, s.rst[i])
+			}
+		}
+		prev = s.end
+	}
+	out = append(out, j.data[prev:]...)
+	_, err := w.Write(out)
+	return err
+}
@@ -0,0 +1,77 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"crypto/rand"
+	"image/color"
+	"image/jpeg"
+
+	"chrispennello.com/go/steg"
+)
+
+// testJPEGBytes returns a baseline JPEG of random pixels, in color with
+// subsampled chroma, or in grayscale.
+func testJPEGBytes(t *testing.T, width, height int, gray bool) []byte {
+	pix := make([]byte, width*height*3)
+	if _, err := rand.Read(pix); err != nil {
+		t.Fatal(err)
+	}
+	var m image.Image
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, width, height))
+		copy(g.Pix, pix)
+		m = g
+	} else {
+		c := image.NewRGBA(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			c.Set(i%width, i/width, color.RGBA{pix[3*i], pix[3*i+1], pix[3*i+2], 0xff})
+		}
+		m = c
+	}
+	b := new(bytes.Buffer)
+	if err := jpeg.Encode(b, m, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func testJPEG(t *testing.T, width, height int, gray bool) {
+	src := testJPEGBytes(t, width, height, gray)
+	testEncodeUnchanged(t, src, decoders["jpeg"])
+	_, out := testRoundTrip(t, steg.NewPlaneCtx(1, 0xff), src, decoders["jpeg"])
+	if bytes.Equal(out, src) {
+		t.Fatal("image unchanged")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("encoded image doesn't decode: %v", err)
+	}
+}
+
+func TestJPEG(t *testing.T) {
+	// Sizes not a multiple of the MCU size have partial MCUs.
+	testJPEG(t, 64, 48, false)
+	testJPEG(t, 37, 23, false)
+	testJPEG(t, 37, 23, true)
+}
+
+func TestJPEGUnsupported(t *testing.T) {
+	src := testJPEGBytes(t, 16, 16, true)
+	i := bytes.Index(src, []byte{0xff, 0xc0})
+	if i < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	// Mark the image progressive.
+	prog := append([]byte(nil), src...)
+	prog[i+1] = 0xc2
+	if _, err := DecodeJPEG(bytes.NewReader(prog)); err != ErrUnsupported {
+		t.Errorf("progressive: %v (expected %v)", err, ErrUnsupported)
+	}
+	if _, err := DecodeJPEG(bytes.NewReader(src[:len(src)/2])); err != ErrMalformed {
+		t.Errorf("truncated: %v (expected %v)", err, ErrMalformed)
+	}
+}
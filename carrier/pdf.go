@@ -0,0 +1,138 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"io"
+
+	"io/ioutil"
+)
+
+// PDF is a Carrier for PDF documents.  Its carrier bits are the
+// least-significant bits of the bytes of comments, which readers
+// ignore, in the order they appear.
+//
+// Only least-significant bits are exposed, and not those of bytes that
+// could flip to or from a line ending, so comments always end where
+// they did.  The first four bytes of each comment are never exposed,
+// nor any bytes of the special comments they begin, such as the header
+// and %%EOF marker.  Strings and streams are skipped, since a percent
+// sign within them doesn't begin a comment.  Everything other than
+// comments is left untouched, so the encoded document has the same
+// objects, cross-reference offsets, and length as the original.
+//
+// Most documents have few comments, if any, and so little capacity.
+type PDF struct {
+	bitSamples
+	data []byte
+}
+
+func isPDFSpace(b byte) bool {
+	switch b {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFDelim(b byte) bool {
+	switch b {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return isPDFSpace(b)
+}
+
+// pdfComment appends the offsets of the exposable bytes of the comment
+// body between start and end.
+func pdfComment(offs []int, data []byte, start, end int) []int {
+	const skip = 4
+	if end-start <= skip {
+		return offs
+	}
+	head := data[start : start+skip]
+	if head[0] == '%' || string(head) == "PDF-" || string(head) == "FDF-" {
+		return offs
+	}
+	for i := start + skip; i < end; i++ {
+		if b := data[i] | 1; b != '\n'|1 && b != '\r'|1 {
+			offs = append(offs, i)
+		}
+	}
+	return offs
+}
+
+// DecodePDF decodes a PDF document from r, ready for embedding.
+func DecodePDF(r io.Reader) (*PDF, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, ErrMalformed
+	}
+	p := &PDF{data: data}
+	// Offsets of the bytes whose bits are exposed.
+	var offs []int
+	for i := 0; i < len(data); {
+		switch b := data[i]; {
+		case b == '%':
+			end := i + 1
+			for end < len(data) && data[end] != '\n' && data[end] != '\r' {
+				end++
+			}
+			offs = pdfComment(offs, data, i+1, end)
+			i = end
+		case b == '(':
+			// A literal string, with balanced parentheses and
+			// backslash escapes.
+			depth := 1
+			for i++; i < len(data) && depth > 0; i++ {
+				switch data[i] {
+				case '\\':
+					i++
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+			}
+			if depth > 0 {
+				return nil, ErrMalformed
+			}
+		case b == '<' && i+1 < len(data) && data[i+1] == '<':
+			// The start of a dictionary.
+			i += 2
+		case b == '<':
+			// A hexadecimal string.
+			n := bytes.IndexByte(data[i:], '>')
+			if n < 0 {
+				return nil, ErrMalformed
+			}
+			i += n + 1
+		case bytes.HasPrefix(data[i:], []byte("stream")) && (i == 0 || isPDFDelim(data[i-1])) &&
+			i+6 < len(data) && (data[i+6] == '\r' || data[i+6] == '\n'):
+			n := bytes.Index(data[i:], []byte("endstream"))
+			if n < 0 {
+				return nil, ErrMalformed
+			}
+			i += n + len("endstream")
+		default:
+			i++
+		}
+	}
+
+	for _, off := range offs {
+		p.add(&data[off], 1, false)
+	}
+	p.pack()
+	return p, nil
+}
+
+// Encode writes the document, with its current carrier bits, to w.
+func (p *PDF) Encode(w io.Writer) error {
+	p.unpack()
+	_, err := w.Write(p.data)
+	return err
+}
@@ -0,0 +1,88 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"chrispennello.com/go/steg"
+)
+
+// testPDFComment is the body of each of the ordinary comments in
+// testPDFBytes.
+var testPDFComment = strings.Repeat("comment ", 125)
+
+// testPDFBytes returns a document with two ordinary comments, and
+// percent signs in strings and a stream, along with the offsets of the
+// ordinary comments' bodies.
+func testPDFBytes() ([]byte, []int) {
+	b := new(bytes.Buffer)
+	var xref, comments []int
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	b.WriteString("%")
+	comments = append(comments, b.Len())
+	b.WriteString(testPDFComment + "\n")
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R " +
+			"/Title (100% (nested) \\) %) /ID <deadbeef> >>",
+		"<< /Length 36 >>\nstream\nBT /F1 12 Tf (% not a comment) Tj ET\nendstream",
+	}
+	for i, obj := range objs {
+		xref = append(xref, b.Len())
+		fmt.Fprintf(b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("%")
+	comments = append(comments, b.Len())
+	b.WriteString(testPDFComment + "\r\n")
+	start := b.Len()
+	fmt.Fprintf(b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range xref {
+		fmt.Fprintf(b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, start)
+	return b.Bytes(), comments
+}
+
+func TestPDF(t *testing.T) {
+	ctx := steg.NewPlaneCtx(1, 0xff)
+	src, comments := testPDFBytes()
+
+	testEncodeUnchanged(t, src, decoders["pdf"])
+	c, out := testRoundTrip(t, ctx, src, decoders["pdf"])
+	if n := 2 * (len(testPDFComment) - 4) / 8; len(c.Samples()) != n {
+		t.Fatalf("%v samples (expected %v)", len(c.Samples()), n)
+	}
+	if len(out) != len(src) {
+		t.Fatalf("length changed from %v to %v", len(src), len(out))
+	}
+	// Only the least-significant bits of the ordinary comments'
+	// bytes changed.
+	for i := range src {
+		if src[i] == out[i] {
+			continue
+		}
+		inComment := false
+		for _, off := range comments {
+			inComment = inComment || i >= off+4 && i < off+len(testPDFComment)
+		}
+		if !inComment || src[i]^out[i] != 1 {
+			t.Fatalf("byte %v changed from %#x to %#x", i, src[i], out[i])
+		}
+	}
+}
+
+func TestPDFMalformed(t *testing.T) {
+	src, _ := testPDFBytes()
+	if _, err := DecodePDF(bytes.NewReader(src[1:])); err != ErrMalformed {
+		t.Errorf("no header: %v (expected %v)", err, ErrMalformed)
+	}
+	i := bytes.Index(src, []byte("endstream"))
+	if _, err := DecodePDF(bytes.NewReader(src[:i])); err != ErrMalformed {
+		t.Errorf("unterminated stream: %v (expected %v)", err, ErrMalformed)
+	}
+}
@@ -0,0 +1,141 @@
+// chris 101826
+
+package carrier
+
+import (
+	"errors"
+	"image"
+	"io"
+
+	"image/png"
+)
+
+// ErrUnsupported is returned when a carrier is well-formed, but uses a
+// variant of its format that the adapter doesn't know how to embed
+// into.
+var ErrUnsupported = errors.New("unsupported carrier variant")
+
+// PNG is a Carrier for PNG images.  Its samples are the color samples
+// of the image's pixels in row-major order: gray, or red, green, and
+// blue.  Alpha samples are never exposed, since changes to them are
+// conspicuous.  For images with 16-bit samples, only the
+// least-significant byte of each sample is exposed.
+//
+// Paletted images are converted to non-paletted color, since changing a
+// palette index can change the color of a pixel arbitrarily.  The
+// encoded image is therefore no longer paletted, but the conversion is
+// lossless.
+type PNG struct {
+	img     image.Image
+	layout  *layout
+	samples []byte
+}
+
+// layout describes where an image's samples lie in its pixel buffer.
+type layout struct {
+	pix    []byte
+	stride int
+	rect   image.Rectangle
+	// Bytes per pixel.
+	size int
+	// Offsets of the exposed samples within each pixel.
+	offsets []int
+	// Whether the rows are stored bottom to top, as in BMP files.
+	bottomUp bool
+}
+
+func newLayout(img image.Image) (*layout, error) {
+	switch m := img.(type) {
+	case *image.Gray:
+		return &layout{m.Pix, m.Stride, m.Rect, 1, []int{0}, false}, nil
+	case *image.Gray16:
+		return &layout{m.Pix, m.Stride, m.Rect, 2, []int{1}, false}, nil
+	case *image.RGBA:
+		return &layout{m.Pix, m.Stride, m.Rect, 4, []int{0, 1, 2}, false}, nil
+	case *image.NRGBA:
+		return &layout{m.Pix, m.Stride, m.Rect, 4, []int{0, 1, 2}, false}, nil
+	case *image.RGBA64:
+		return &layout{m.Pix, m.Stride, m.Rect, 8, []int{1, 3, 5}, false}, nil
+	case *image.NRGBA64:
+		return &layout{m.Pix, m.Stride, m.Rect, 8, []int{1, 3, 5}, false}, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// index returns the index into l.pix of the first byte of the pixel at
+// (x, y), relative to the image's bounds.
+func (l *layout) index(x, y int) int {
+	if l.bottomUp {
+		y = l.rect.Dy() - 1 - y
+	}
+	return y*l.stride + x*l.size
+}
+
+// gather copies the exposed samples out of the pixel buffer.
+func (l *layout) gather() []byte {
+	dx, dy := l.rect.Dx(), l.rect.Dy()
+	samples := make([]byte, 0, dx*dy*len(l.offsets))
+	for y := 0; y < dy; y++ {
+		for x := 0; x < dx; x++ {
+			i := l.index(x, y)
+			for _, off := range l.offsets {
+				samples = append(samples, l.pix[i+off])
+			}
+		}
+	}
+	return samples
+}
+
+// scatter copies the exposed samples back into the pixel buffer.
+func (l *layout) scatter(samples []byte) {
+	dx, dy := l.rect.Dx(), l.rect.Dy()
+	for y := 0; y < dy; y++ {
+		for x := 0; x < dx; x++ {
+			i := l.index(x, y)
+			for _, off := range l.offsets {
+				l.pix[i+off] = samples[0]
+				samples = samples[1:]
+			}
+		}
+	}
+}
+
+// toNRGBA converts a paletted image to an equivalent non-paletted one.
+func toNRGBA(p *image.Paletted) *image.NRGBA {
+	b := p.Bounds()
+	m := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			m.Set(x, y, p.At(x, y))
+		}
+	}
+	return m
+}
+
+// DecodePNG decodes a PNG image from r, ready for embedding.  Can
+// return ErrUnsupported for images with unusual pixel formats.
+func DecodePNG(r io.Reader) (*PNG, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := img.(*image.Paletted); ok {
+		img = toNRGBA(p)
+	}
+	l, err := newLayout(img)
+	if err != nil {
+		return nil, err
+	}
+	return &PNG{img: img, layout: l, samples: l.gather()}, nil
+}
+
+// Samples returns the exposed color samples of the image.
+func (p *PNG) Samples() []byte {
+	return p.samples
+}
+
+// Encode writes the image, with its current samples, to w as a PNG.
+func (p *PNG) Encode(w io.Writer) error {
+	p.layout.scatter(p.samples)
+	return png.Encode(w, p.img)
+}
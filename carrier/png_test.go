@@ -0,0 +1,98 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"crypto/rand"
+	"image/color"
+	"image/png"
+
+	"chrispennello.com/go/steg"
+)
+
+func testPNGImage(t *testing.T, img image.Image) {
+	ctx := steg.NewPlaneCtx(1, 0x01)
+
+	src := new(bytes.Buffer)
+	if err := png.Encode(src, img); err != nil {
+		t.Fatal(err)
+	}
+	_, dst := testRoundTrip(t, ctx, src.Bytes(), decoders["png"])
+	out, err := png.Decode(bytes.NewReader(dst))
+	if err != nil {
+		t.Fatalf("muxed output is not a valid PNG: %v", err)
+	}
+	testPNGSimilar(t, img, out)
+}
+
+// testPNGSimilar checks that the pixel buffers of a and b differ only
+// in the least-significant bits of their exposed samples.
+func testPNGSimilar(t *testing.T, a, b image.Image) {
+	if p, ok := a.(*image.Paletted); ok {
+		a = toNRGBA(p)
+	}
+	la, err := newLayout(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := newLayout(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if la.rect != lb.rect || la.size != lb.size || len(la.pix) != len(lb.pix) {
+		t.Fatalf("layouts differ: %v, %v", la.rect, lb.rect)
+	}
+	exposed := make(map[int]bool)
+	for _, off := range la.offsets {
+		exposed[off] = true
+	}
+	for i := range la.pix {
+		d := la.pix[i] ^ lb.pix[i]
+		if !exposed[i%la.size] && d != 0 || d&^0x01 != 0 {
+			t.Fatalf("pixel byte %v differs: %#x, %#x", i, la.pix[i], lb.pix[i])
+		}
+	}
+}
+
+func testRandomPix(t *testing.T, pix []byte) {
+	if _, err := rand.Read(pix); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	r := image.Rect(0, 0, 37, 29)
+
+	gray := image.NewGray(r)
+	testRandomPix(t, gray.Pix)
+	testPNGImage(t, gray)
+
+	gray16 := image.NewGray16(r)
+	testRandomPix(t, gray16.Pix)
+	testPNGImage(t, gray16)
+
+	nrgba := image.NewNRGBA(r)
+	testRandomPix(t, nrgba.Pix)
+	testPNGImage(t, nrgba)
+
+	rgba := image.NewRGBA(r)
+	testRandomPix(t, rgba.Pix)
+	for i := 3; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i] = 0xff
+	}
+	testPNGImage(t, rgba)
+
+	paletted := image.NewPaletted(r, color.Palette{
+		color.NRGBA{0xff, 0, 0, 0xff},
+		color.NRGBA{0, 0xff, 0, 0x80},
+		color.NRGBA{0, 0, 0xff, 0xff},
+	})
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 3)
+	}
+	testPNGImage(t, paletted)
+}
@@ -0,0 +1,150 @@
+// chris 101826
+
+package carrier
+
+import (
+	"image"
+	"io"
+
+	"io/ioutil"
+)
+
+// PNM is a Carrier for binary PGM and PPM images, the P5 and P6
+// variants of the Netpbm formats.  Its samples are the gray, or red,
+// green, and blue, samples of each pixel in row-major order.  For
+// images with a maximum sample value above 255, only the
+// least-significant byte of each sample is exposed.
+//
+// Changing the exposed bytes must not push a sample past the maximum
+// sample value, so that has to be 255, or above 255 with a
+// least-significant byte of 0xff, as for 10-, 12-, and 16-bit images.
+// Other maximum values are unsupported.
+//
+// All other bytes of the file, including any images following the
+// first, are left untouched, so the encoded file has the same headers
+// and length as the original.
+type PNM struct {
+	data    []byte
+	layout  *layout
+	samples []byte
+}
+
+// pnmHeader parses the width, height, and maximum sample value
+// following the magic number of a PNM header, returning them along with
+// the offset of the raster following it.
+func pnmHeader(data []byte) (fields [3]int64, off int, err error) {
+	off = 2
+	for i := range fields {
+		// Skip whitespace and comments.
+		for off < len(data) {
+			if data[off] == '#' {
+				for off < len(data) && data[off] != '\n' && data[off] != '\r' {
+					off++
+				}
+				continue
+			}
+			if !isPNMSpace(data[off]) {
+				break
+			}
+			off++
+		}
+		start := off
+		for off < len(data) && data[off] >= '0' && data[off] <= '9' {
+			if off-start >= 9 {
+				// Too big to be sensible.
+				return fields, 0, ErrMalformed
+			}
+			fields[i] = fields[i]*10 + int64(data[off]-'0')
+			off++
+		}
+		if off == start {
+			return fields, 0, ErrMalformed
+		}
+	}
+	// A single whitespace byte precedes the raster.
+	if off >= len(data) || !isPNMSpace(data[off]) {
+		return fields, 0, ErrMalformed
+	}
+	return fields, off + 1, nil
+}
+
+func isPNMSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// DecodePNM decodes a binary PGM or PPM image from r, ready for
+// embedding.  Can return ErrUnsupported for other Netpbm variants, such
+// as plain text ones and bitmaps, and for unsupported maximum sample
+// values.
+func DecodePNM(r io.Reader) (*PNM, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != 'P' {
+		return nil, ErrMalformed
+	}
+	var channels int64
+	switch data[1] {
+	case '5':
+		channels = 1
+	case '6':
+		channels = 3
+	case '1', '2', '3', '4', '7':
+		return nil, ErrUnsupported
+	default:
+		return nil, ErrMalformed
+	}
+	fields, off, err := pnmHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	width, height, maxval := fields[0], fields[1], fields[2]
+	if width == 0 || height == 0 || maxval == 0 || maxval > 65535 {
+		return nil, ErrMalformed
+	}
+	if maxval&0xff != 0xff {
+		// Some values of the exposed byte would be out of range.
+		return nil, ErrUnsupported
+	}
+	// Bytes per sample.
+	bps := int64(1)
+	if maxval > 255 {
+		// Samples are big-endian, so the least-significant
+		// byte follows.
+		bps = 2
+	}
+	size := channels * bps
+	stride := width * size
+	if stride*height > int64(len(data)-off) {
+		return nil, ErrMalformed
+	}
+	offsets := make([]int, channels)
+	for i := range offsets {
+		offsets[i] = int(int64(i)*bps + bps - 1)
+	}
+	l := &layout{
+		pix:     data[off : int64(off)+stride*height],
+		stride:  int(stride),
+		rect:    image.Rect(0, 0, int(width), int(height)),
+		size:    int(size),
+		offsets: offsets,
+	}
+	return &PNM{data: data, layout: l, samples: l.gather()}, nil
+}
+
+// Samples returns the least-significant bytes of the image's samples.
+func (p *PNM) Samples() []byte {
+	return p.samples
+}
+
+// Encode writes the image, with its current samples, to w.
+func (p *PNM) Encode(w io.Writer) error {
+	p.layout.scatter(p.samples)
+	_, err := w.Write(p.data)
+	return err
+}
@@ -0,0 +1,102 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"crypto/rand"
+
+	"chrispennello.com/go/steg"
+)
+
+// testPNMBytes returns a binary PNM file with a comment in its header,
+// random samples no greater than maxval, and trailing bytes, along with
+// the offset of the raster.
+func testPNMBytes(t *testing.T, magic string, width, height, maxval int) ([]byte, int) {
+	channels := 1
+	if magic == "P6" {
+		channels = 3
+	}
+	bps := 1
+	if maxval > 255 {
+		bps = 2
+	}
+	raster := make([]byte, width*height*channels*bps)
+	if _, err := rand.Read(raster); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(raster); i += bps {
+		if bps == 1 {
+			raster[i] = byte(int(raster[i]) % (maxval + 1))
+		} else {
+			// Big-endian.
+			raster[i] = byte(int(raster[i]) % (maxval>>8 + 1))
+		}
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "%s\n# made by steg\n%d %d\n%d\n", magic, width, height, maxval)
+	off := b.Len()
+	b.Write(raster)
+	b.WriteString("trailing")
+	return b.Bytes(), off
+}
+
+func testPNM(t *testing.T, magic string, maxval int) {
+	ctx := steg.NewPlaneCtx(1, 0x03)
+	src, off := testPNMBytes(t, magic, 31, 7, maxval)
+	bps := 1
+	if maxval > 255 {
+		bps = 2
+	}
+	end := len(src) - len("trailing")
+
+	testEncodeUnchanged(t, src, decoders["pnm"])
+	c, out := testRoundTrip(t, ctx, src, decoders["pnm"])
+	if len(c.Samples()) != (end-off)/bps {
+		t.Fatalf("%v samples (expected %v)", len(c.Samples()), (end-off)/bps)
+	}
+	if len(out) != len(src) {
+		t.Fatalf("length changed from %v to %v", len(src), len(out))
+	}
+	for i := range src {
+		if src[i] != out[i] && (i < off || i >= end || (i-off)%bps != bps-1) {
+			t.Fatalf("byte %v outside of sample LSBs changed", i)
+		}
+	}
+	for i := off; i < end; i += bps {
+		v := int(out[i])
+		if bps == 2 {
+			v = v<<8 | int(out[i+1])
+		}
+		if v > maxval {
+			t.Fatalf("sample at %v is %v, past maxval %v", i, v, maxval)
+		}
+	}
+}
+
+func TestPNM(t *testing.T) {
+	testPNM(t, "P5", 255)
+	testPNM(t, "P5", 65535)
+	testPNM(t, "P6", 255)
+	testPNM(t, "P6", 1023)
+	testPNM(t, "P5", 4095)
+}
+
+func TestPNMMalformed(t *testing.T) {
+	src, off := testPNMBytes(t, "P6", 4, 4, 255)
+	if _, err := DecodePNM(bytes.NewReader(src[:off+10])); err != ErrMalformed {
+		t.Errorf("truncated: %v (expected %v)", err, ErrMalformed)
+	}
+	if _, err := DecodePNM(bytes.NewReader([]byte("P3\n1 1\n255\n0 0 0\n"))); err != ErrUnsupported {
+		t.Errorf("plain: %v (expected %v)", err, ErrUnsupported)
+	}
+	for _, maxval := range []int{100, 1000} {
+		src, _ := testPNMBytes(t, "P5", 4, 4, maxval)
+		if _, err := DecodePNM(bytes.NewReader(src)); err != ErrUnsupported {
+			t.Errorf("maxval %v: %v (expected %v)", maxval, err, ErrUnsupported)
+		}
+	}
+}
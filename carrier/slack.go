@@ -0,0 +1,35 @@
+// chris 101826
+
+package carrier
+
+// A span is a region of a file, from its start offset up to its end.
+type span struct {
+	start, end int
+}
+
+// slack describes the slack space of a container file: regions of it
+// that no reader interprets, or at least none minds the contents of.
+type slack struct {
+	data  []byte
+	spans []span
+}
+
+// gather copies the slack out of the file.
+func (s *slack) gather() []byte {
+	n := 0
+	for _, sp := range s.spans {
+		n += sp.end - sp.start
+	}
+	samples := make([]byte, 0, n)
+	for _, sp := range s.spans {
+		samples = append(samples, s.data[sp.start:sp.end]...)
+	}
+	return samples
+}
+
+// scatter copies the slack back into the file.
+func (s *slack) scatter(samples []byte) {
+	for _, sp := range s.spans {
+		samples = samples[copy(s.data[sp.start:sp.end], samples):]
+	}
+}
@@ -0,0 +1,199 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"io/ioutil"
+)
+
+const tarBlock = 512
+
+// TAR is a Carrier for tar archives.  Its samples are the padding
+// rounding each member's data up to a whole 512-byte block, which tar
+// readers skip without looking at.  Headers, member data, and the end
+// of the archive are left untouched, so the encoded archive has the
+// same members and length as the original.
+//
+// A size in a PAX extended header overrides the size in the header of
+// the member it precedes, as for sizes too big for the header.  Old GNU
+// sparse members, whose headers continue into the blocks following
+// them, and sizes in PAX global headers are unsupported.
+type TAR struct {
+	slack   *slack
+	samples []byte
+}
+
+// parseTarNumber parses a numeric header field, in octal or, for large
+// values, base-256.
+func parseTarNumber(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		if field[0] != 0x80 {
+			// Negative, or too big.
+			return 0, ErrMalformed
+		}
+		var v int64
+		for _, b := range field[1:] {
+			if v>>55 != 0 {
+				return 0, ErrMalformed
+			}
+			v = v<<8 | int64(b)
+		}
+		return v, nil
+	}
+	s := string(bytes.Trim(field, " \x00"))
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseInt(s, 8, 64)
+	if err != nil || v < 0 {
+		return 0, ErrMalformed
+	}
+	return v, nil
+}
+
+// validTarHeader returns whether a header block's checksum is correct.
+// Some old implementations summed signed bytes, so either sum will do.
+func validTarHeader(block []byte) bool {
+	sum, err := parseTarNumber(block[148:156])
+	if err != nil {
+		return false
+	}
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return sum == unsigned || sum == signed
+}
+
+// parsePAXSize parses the records of a PAX extended header, returning
+// the size they give, if any.
+func parsePAXSize(records []byte) (size int64, ok bool, err error) {
+	for len(records) > 0 {
+		// Each record is "length key=value\n", its length counting
+		// itself.
+		sp := bytes.IndexByte(records, ' ')
+		if sp <= 0 {
+			return 0, false, ErrMalformed
+		}
+		n, err := strconv.Atoi(string(records[:sp]))
+		if err != nil || n <= sp+1 || n > len(records) || records[n-1] != '\n' {
+			return 0, false, ErrMalformed
+		}
+		kv := records[sp+1 : n-1]
+		records = records[n:]
+		eq := bytes.IndexByte(kv, '=')
+		if eq < 0 {
+			return 0, false, ErrMalformed
+		}
+		if string(kv[:eq]) != "size" {
+			continue
+		}
+		if eq+1 == len(kv) {
+			// An empty value removes the size.
+			size, ok = 0, false
+			continue
+		}
+		size, err = strconv.ParseInt(string(kv[eq+1:]), 10, 64)
+		if err != nil || size < 0 {
+			return 0, false, ErrMalformed
+		}
+		ok = true
+	}
+	return size, ok, nil
+}
+
+// DecodeTAR decodes a tar archive from r, ready for embedding.  Can
+// return ErrUnsupported for archives with old GNU sparse members or
+// sizes in PAX global headers.
+func DecodeTAR(r io.Reader) (*TAR, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &slack{data: data}
+	zero := make([]byte, tarBlock)
+	off := 0
+	// A size from a PAX extended header, for the next member.
+	var paxSize int64
+	var paxOK bool
+	for off < len(data) {
+		if off+tarBlock > len(data) {
+			return nil, ErrMalformed
+		}
+		block := data[off : off+tarBlock]
+		if bytes.Equal(block, zero) {
+			// The end of the archive.
+			break
+		}
+		if !validTarHeader(block) {
+			return nil, ErrMalformed
+		}
+		size, err := parseTarNumber(block[124:136])
+		if err != nil {
+			return nil, err
+		}
+		typ := block[156]
+		switch typ {
+		case 'x', 'g', 'L', 'K':
+			// Headers describing the next member.
+		default:
+			if paxOK {
+				size = paxSize
+			}
+			paxSize, paxOK = 0, false
+		}
+		switch typ {
+		case '1', '2', '3', '4', '5', '6':
+			// Header-only members, whatever their sizes say.
+			size = 0
+		case 'S':
+			return nil, ErrUnsupported
+		}
+		off += tarBlock
+		if size > int64(len(data)-off) {
+			return nil, ErrMalformed
+		}
+		end := off + int(size)
+		if typ == 'x' || typ == 'g' {
+			psize, ok, err := parsePAXSize(data[off:end])
+			if err != nil {
+				return nil, err
+			}
+			if ok && typ == 'g' {
+				return nil, ErrUnsupported
+			}
+			if ok {
+				paxSize, paxOK = psize, true
+			}
+		}
+		padded := (end + tarBlock - 1) / tarBlock * tarBlock
+		if padded > len(data) {
+			return nil, ErrMalformed
+		}
+		if padded > end {
+			s.spans = append(s.spans, span{end, padded})
+		}
+		off = padded
+	}
+	return &TAR{slack: s, samples: s.gather()}, nil
+}
+
+// Samples returns the padding of the archive's members.
+func (t *TAR) Samples() []byte {
+	return t.samples
+}
+
+// Encode writes the archive, with its current samples, to w.
+func (t *TAR) Encode(w io.Writer) error {
+	t.slack.scatter(t.samples)
+	_, err := w.Write(t.slack.data)
+	return err
+}
@@ -0,0 +1,178 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"archive/tar"
+	"crypto/rand"
+	"io/ioutil"
+
+	"chrispennello.com/go/steg"
+)
+
+// testTARBytes returns an archive of members of random data of the
+// given sizes, and a symbolic link.
+func testTARBytes(t *testing.T, sizes []int) ([]byte, [][]byte) {
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	var files [][]byte
+	for i, size := range sizes {
+		p := make([]byte, size)
+		if _, err := rand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		hdr := &tar.Header{Name: string('a' + rune(i)), Mode: 0644, Size: int64(size)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, p)
+	}
+	hdr := &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a"}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes(), files
+}
+
+// testTARMembers checks that out reads as an archive of the files,
+// followed by n other members.
+func testTARMembers(t *testing.T, out []byte, files [][]byte, n int) {
+	tr := tar.NewReader(bytes.NewReader(out))
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if i != len(files)+n {
+				t.Fatalf("%v members (expected %v)", i, len(files)+n)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < len(files) && !bytes.Equal(p, files[i]) {
+			t.Fatalf("member %v changed", hdr.Name)
+		}
+	}
+}
+
+// testTARChecksum recomputes the checksum of a header block.
+func testTARChecksum(block []byte) {
+	copy(block[148:156], "        ")
+	sum := 0
+	for _, b := range block[:512] {
+		sum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+}
+
+// testTARPAXBytes returns an archive of a member of random data whose
+// size is given only by a PAX extended header, its header's size being
+// zero.
+func testTARPAXBytes(t *testing.T, size int) ([]byte, []byte) {
+	p := make([]byte, size)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	hdr := &tar.Header{
+		Name:   "a",
+		Mode:   0644,
+		Size:   int64(size),
+		Format: tar.FormatPAX,
+		// Replaced by a size record of the same length.
+		PAXRecords: map[string]string{"comment": "xxxxx"},
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src := b.Bytes()
+	if src[156] != 'x' {
+		t.Fatal("no PAX extended header")
+	}
+	rec := []byte("comment=xxxxx")
+	i := bytes.Index(src[512:1024], rec)
+	if i < 0 {
+		t.Fatal("no comment record")
+	}
+	copy(src[512+i:], fmt.Sprintf("size=%08d", size))
+	copy(src[1024+124:1024+136], "00000000000\x00")
+	testTARChecksum(src[1024:])
+	return src, p
+}
+
+func TestTAR(t *testing.T) {
+	ctx := steg.NewPlaneCtx(1, 0xff)
+	sizes := []int{1, 512, 1000, 3000, 0, 77}
+	src, files := testTARBytes(t, sizes)
+
+	testEncodeUnchanged(t, src, decoders["tar"])
+	c, out := testRoundTrip(t, ctx, src, decoders["tar"])
+	padding := 0
+	for _, size := range sizes {
+		padding += (512 - size%512) % 512
+	}
+	if len(c.Samples()) != padding {
+		t.Fatalf("%v samples (expected %v)", len(c.Samples()), padding)
+	}
+	if len(out) != len(src) {
+		t.Fatalf("length changed from %v to %v", len(src), len(out))
+	}
+	// The archive still reads the same.
+	testTARMembers(t, out, files, 1)
+}
+
+func TestTARPAX(t *testing.T) {
+	ctx := steg.NewPlaneCtx(1, 0xff)
+	src, p := testTARPAXBytes(t, 1000)
+	// The archive reads as the member, at the size the PAX header
+	// gives.
+	testTARMembers(t, src, [][]byte{p}, 0)
+
+	c, out := testRoundTrip(t, ctx, src, decoders["tar"])
+	// The PAX header's records, 17 bytes, and the member are padded.
+	if padding := 512 - 17 + 24; len(c.Samples()) != padding {
+		t.Fatalf("%v samples (expected %v)", len(c.Samples()), padding)
+	}
+	testTARMembers(t, out, [][]byte{p}, 0)
+
+	// A size in a global header isn't supported.
+	src[156] = 'g'
+	testTARChecksum(src)
+	if _, err := DecodeTAR(bytes.NewReader(src)); err != ErrUnsupported {
+		t.Errorf("global size: %v (expected %v)", err, ErrUnsupported)
+	}
+}
+
+func TestTARMalformed(t *testing.T) {
+	src, _ := testTARBytes(t, []int{100})
+	bad := append([]byte(nil), src...)
+	bad[0] ^= 1
+	if _, err := DecodeTAR(bytes.NewReader(bad)); err != ErrMalformed {
+		t.Errorf("bad checksum: %v (expected %v)", err, ErrMalformed)
+	}
+	if _, err := DecodeTAR(bytes.NewReader(src[:600])); err != ErrMalformed {
+		t.Errorf("truncated: %v (expected %v)", err, ErrMalformed)
+	}
+}
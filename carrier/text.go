@@ -0,0 +1,193 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"io"
+
+	"io/ioutil"
+)
+
+// zeroWidthSpace is U+200B, encoded in UTF-8.
+const zeroWidthSpace = "\u200b"
+
+// Kinds of textSlot.
+const (
+	slotTrailing = iota
+	slotDouble
+	slotZeroWidth
+)
+
+// Text is a Carrier for plain text.  Its carrier bits are choices of
+// whitespace that don't change how the text reads, in the order they
+// appear:
+//
+//   - after sentence-ending punctuation followed by spaces and more
+//     text on the same line, whether there are one or several spaces;
+//   - optionally, after each run of spaces or tabs between two words,
+//     whether a zero-width space follows the run;
+//   - at the end of each line terminated by a newline, whether there's
+//     trailing whitespace.
+//
+// Where the text already makes a choice, Encode leaves its whitespace
+// alone, and otherwise writes a single space, two spaces, or a single
+// zero-width space.  Which places are choices depends only on the
+// characters around them, which embedding never changes, so the carrier
+// bits of an encoded text are where they were in the original.
+//
+// The text must be in an ASCII-compatible encoding, and in UTF-8 for
+// zero-width spaces.
+type Text struct {
+	bitSamples
+	data  []byte
+	slots []textSlot
+	// Whether each slot's carrier bit is set.
+	set []byte
+}
+
+// A textSlot is the whitespace of a carrier bit, as in the original
+// text.
+type textSlot struct {
+	off, n int
+	kind   int
+}
+
+// set returns whether the original whitespace sets the carrier bit.
+func (s textSlot) set() bool {
+	if s.kind == slotDouble {
+		return s.n > 1
+	}
+	return s.n > 0
+}
+
+// render returns the whitespace for the carrier bit.
+func (s textSlot) render(data []byte, set bool) []byte {
+	if set == s.set() {
+		return data[s.off : s.off+s.n]
+	}
+	switch {
+	case s.kind == slotDouble && set:
+		return []byte("  ")
+	case s.kind == slotDouble:
+		return []byte(" ")
+	case s.kind == slotZeroWidth && set:
+		return []byte(zeroWidthSpace)
+	case set:
+		return []byte(" ")
+	}
+	return nil
+}
+
+func isTextSpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+// textSlots returns the slots of a line, not including its terminator,
+// starting at off in the text.
+func textSlots(line []byte, off int, zeroWidth bool) []textSlot {
+	var slots []textSlot
+	// Trailing whitespace.
+	end := len(line)
+	for end > 0 && isTextSpace(line[end-1]) {
+		end--
+	}
+	// Skip indentation.
+	i := 0
+	for i < end && isTextSpace(line[i]) {
+		i++
+	}
+	for i < end {
+		if !isTextSpace(line[i]) {
+			i++
+			continue
+		}
+		start := i
+		spaces := true
+		for isTextSpace(line[i]) {
+			spaces = spaces && line[i] == ' '
+			i++
+		}
+		// A zero-width space may follow the run, but only before
+		// a word.
+		rest := line[i:end]
+		zw := bytes.HasPrefix(rest, []byte(zeroWidthSpace))
+		word := rest
+		if zw {
+			word = rest[len(zeroWidthSpace):]
+		}
+		slot := len(word) > 0 && !isTextSpace(word[0]) && !bytes.HasPrefix(word, []byte(zeroWidthSpace))
+		if spaces {
+			switch line[start-1] {
+			case '.', '!', '?':
+				slots = append(slots, textSlot{off + start, i - start, slotDouble})
+			}
+		}
+		if zeroWidth && slot {
+			n := 0
+			if zw {
+				n = len(zeroWidthSpace)
+			}
+			slots = append(slots, textSlot{off + i, n, slotZeroWidth})
+		}
+	}
+	return slots
+}
+
+// DecodeText decodes plain text from r, ready for embedding.  If
+// zeroWidth is true, the choices of zero-width spaces between words are
+// also carrier bits.  Extraction must use the same value of zeroWidth.
+func DecodeText(r io.Reader, zeroWidth bool) (*Text, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &Text{data: data}
+	off := 0
+	for {
+		n := bytes.IndexByte(data[off:], '\n')
+		if n < 0 {
+			// The final, unterminated line has no trailing
+			// slot, since adding whitespace to it could make it
+			// look like one.
+			t.slots = append(t.slots, textSlots(data[off:], off, zeroWidth)...)
+			break
+		}
+		line := data[off : off+n]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		t.slots = append(t.slots, textSlots(line, off, zeroWidth)...)
+		end := len(line)
+		for end > 0 && isTextSpace(line[end-1]) {
+			end--
+		}
+		t.slots = append(t.slots, textSlot{off + end, len(line) - end, slotTrailing})
+		off += n + 1
+	}
+
+	t.set = make([]byte, len(t.slots))
+	for i, s := range t.slots {
+		if s.set() {
+			t.set[i] = 1
+		}
+		t.add(&t.set[i], 1, false)
+	}
+	t.pack()
+	return t, nil
+}
+
+// Encode writes the text, with its current carrier bits, to w.
+func (t *Text) Encode(w io.Writer) error {
+	t.unpack()
+	out := make([]byte, 0, len(t.data)+len(t.slots))
+	prev := 0
+	for i, s := range t.slots {
+		out = append(out, t.data[prev:s.off]...)
+		out = append(out, s.render(t.data, t.set[i] == 1)...)
+		prev = s.off + s.n
+	}
+	out = append(out, t.data[prev:]...)
+	_, err := w.Write(out)
+	return err
+}
@@ -0,0 +1,41 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"chrispennello.com/go/steg"
+)
+
+// A paragraph with indentation, tabs, existing double and trailing
+// spaces, zero-width spaces, and a CRLF line ending.
+const testParagraph = "  Some text.  More text! And\tmore? Yes. \n" +
+	"A line with\u200b a \u200bzero-width space.\r\n" +
+	"Trailing   \t\n" +
+	"\n"
+
+// visible returns text without any of the whitespace Text may change.
+func visible(text []byte) string {
+	return strings.NewReplacer(" ", "", "\t", "", zeroWidthSpace, "").Replace(string(text))
+}
+
+func testText(t *testing.T, format string) {
+	ctx := steg.NewPlaneCtx(1, 0xff)
+	src := []byte(strings.Repeat(testParagraph, 200) + "Unterminated. End")
+	testEncodeUnchanged(t, src, decoders[format])
+	_, out := testRoundTrip(t, ctx, src, decoders[format])
+	if visible(out) != visible(src) {
+		t.Error("text other than whitespace changed")
+	}
+	if format == "text" && bytes.Count(out, []byte(zeroWidthSpace)) != 400 {
+		t.Error("zero-width spaces changed")
+	}
+}
+
+func TestText(t *testing.T) {
+	testText(t, "text")
+	testText(t, "text+zw")
+}
@@ -0,0 +1,153 @@
+// chris 101826
+
+package carrier
+
+import (
+	"errors"
+	"io"
+
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// ErrMalformed is returned when a carrier doesn't conform to the format
+// it's being decoded as.
+var ErrMalformed = errors.New("malformed carrier")
+
+// WAVE format tags.
+const (
+	wavePCM        = 0x0001
+	waveExtensible = 0xfffe
+)
+
+// WAV is a Carrier for RIFF/WAVE audio with PCM samples.  Its samples
+// are the least-significant bytes of each PCM sample of each channel,
+// in file order.  For 8-bit audio, this is the entire sample; for 16-,
+// 24-, or 32-bit audio, the remaining, more significant bytes are never
+// exposed.  All other bytes of the file are left untouched, so the
+// encoded file has the same headers and length as the original.
+type WAV struct {
+	data []byte
+	// Offset and length of the contents of the data chunk.
+	off, n int
+	// Bytes per sample of a single channel.
+	width   int
+	samples []byte
+}
+
+// riffChunk returns the id and contents of the RIFF chunk at the start
+// of p, as well as the remainder of p after the chunk and its padding.
+func riffChunk(p []byte) (id string, body, rest []byte, err error) {
+	if len(p) < 8 {
+		return "", nil, nil, ErrMalformed
+	}
+	id = string(p[0:4])
+	size := int64(binary.LittleEndian.Uint32(p[4:8]))
+	p = p[8:]
+	if size > int64(len(p)) {
+		return "", nil, nil, ErrMalformed
+	}
+	body, rest = p[:size], p[size:]
+	if size%2 == 1 && len(rest) > 0 {
+		// Chunks are padded to an even size.
+		rest = rest[1:]
+	}
+	return id, body, rest, nil
+}
+
+// parseFmt parses the contents of a fmt chunk, returning the number of
+// bytes per sample and per block.
+func parseFmt(body []byte) (width, align int, err error) {
+	if len(body) < 16 {
+		return 0, 0, ErrMalformed
+	}
+	tag := binary.LittleEndian.Uint16(body[0:2])
+	channels := int(binary.LittleEndian.Uint16(body[2:4]))
+	align = int(binary.LittleEndian.Uint16(body[12:14]))
+	bits := int(binary.LittleEndian.Uint16(body[14:16]))
+	if tag == waveExtensible {
+		// The actual format tag leads the sub-format GUID.
+		if len(body) < 26 {
+			return 0, 0, ErrMalformed
+		}
+		tag = binary.LittleEndian.Uint16(body[24:26])
+	}
+	if tag != wavePCM {
+		return 0, 0, ErrUnsupported
+	}
+	if channels < 1 || bits < 1 || bits > 32 {
+		return 0, 0, ErrMalformed
+	}
+	width = (bits + 7) / 8
+	if align != width*channels {
+		return 0, 0, ErrMalformed
+	}
+	return width, align, nil
+}
+
+// DecodeWAV decodes RIFF/WAVE audio from r, ready for embedding.  The
+// fmt and data chunks are located automatically.  Can return
+// ErrUnsupported for non-PCM audio.
+func DecodeWAV(r io.Reader) (*WAV, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	id, body, _, err := riffChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if id != "RIFF" || len(body) < 4 || string(body[0:4]) != "WAVE" {
+		return nil, ErrMalformed
+	}
+	w := &WAV{data: data}
+	var align int
+	rest := body[4:]
+	for len(rest) > 0 && w.n == 0 {
+		id, body, rest, err = riffChunk(rest)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case "fmt ":
+			w.width, align, err = parseFmt(body)
+			if err != nil {
+				return nil, err
+			}
+		case "data":
+			if w.width == 0 {
+				// fmt must precede data.
+				return nil, ErrMalformed
+			}
+			// body is a slice of data, so the difference
+			// in capacity is its offset.
+			w.off = cap(data) - cap(body)
+			// Ignore any trailing partial block.
+			w.n = len(body) / align * align
+		}
+	}
+	if w.n == 0 {
+		return nil, ErrMalformed
+	}
+	w.samples = make([]byte, 0, w.n/w.width)
+	// Samples are little-endian, so the least-significant byte
+	// leads.
+	for i := w.off; i < w.off+w.n; i += w.width {
+		w.samples = append(w.samples, data[i])
+	}
+	return w, nil
+}
+
+// Samples returns the least-significant bytes of the audio samples.
+func (w *WAV) Samples() []byte {
+	return w.samples
+}
+
+// Encode writes the audio, with its current samples, to dst.
+func (w *WAV) Encode(dst io.Writer) error {
+	for i, b := range w.samples {
+		w.data[w.off+i*w.width] = b
+	}
+	_, err := dst.Write(w.data)
+	return err
+}
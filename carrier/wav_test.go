@@ -0,0 +1,90 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"testing"
+
+	"crypto/rand"
+	"encoding/binary"
+
+	"chrispennello.com/go/steg"
+)
+
+// testWAVBytes returns a WAV file with a LIST chunk ahead of the data
+// chunk and random samples.
+func testWAVBytes(t *testing.T, channels, bits, frames int) []byte {
+	width := (bits + 7) / 8
+	align := channels * width
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:], wavePCM)
+	binary.LittleEndian.PutUint16(fmtChunk[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:], 44100)
+	binary.LittleEndian.PutUint32(fmtChunk[8:], uint32(44100*align))
+	binary.LittleEndian.PutUint16(fmtChunk[12:], uint16(align))
+	binary.LittleEndian.PutUint16(fmtChunk[14:], uint16(bits))
+	samples := make([]byte, frames*align)
+	if _, err := rand.Read(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteString("WAVE")
+	chunk := func(id string, p []byte) {
+		body.WriteString(id)
+		binary.Write(body, binary.LittleEndian, uint32(len(p)))
+		body.Write(p)
+		if len(p)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	chunk("fmt ", fmtChunk)
+	chunk("LIST", []byte("INFOISFT\x03\x00\x00\x00go\x00"))
+	chunk("data", samples)
+
+	wav := new(bytes.Buffer)
+	wav.WriteString("RIFF")
+	binary.Write(wav, binary.LittleEndian, uint32(body.Len()))
+	wav.Write(body.Bytes())
+	return wav.Bytes()
+}
+
+func testWAV(t *testing.T, channels, bits int) {
+	ctx := steg.NewCtx(1)
+	src := testWAVBytes(t, channels, bits, 999)
+	width := (bits + 7) / 8
+
+	testEncodeUnchanged(t, src, decoders["wav"])
+	c, out := testRoundTrip(t, ctx, src, decoders["wav"])
+	if len(c.Samples()) != 999*channels {
+		t.Fatalf("%v samples (expected %v)", len(c.Samples()), 999*channels)
+	}
+	if len(out) != len(src) {
+		t.Fatalf("length changed from %v to %v", len(src), len(out))
+	}
+	dataOff := bytes.Index(src, []byte("data")) + 8
+	for i := range src {
+		if src[i] != out[i] && (i < dataOff || i >= dataOff+999*channels*width || (i-dataOff)%width != 0) {
+			t.Fatalf("byte %v outside of sample LSBs changed", i)
+		}
+	}
+}
+
+func TestWAV(t *testing.T) {
+	testWAV(t, 1, 8)
+	testWAV(t, 2, 16)
+	testWAV(t, 3, 24)
+	testWAV(t, 2, 32)
+}
+
+func TestWAVMalformed(t *testing.T) {
+	src := testWAVBytes(t, 2, 16, 10)
+	if _, err := DecodeWAV(bytes.NewReader(src[:40])); err != ErrMalformed {
+		t.Errorf("truncated: %v (expected %v)", err, ErrMalformed)
+	}
+	binary.LittleEndian.PutUint16(src[20:], 3) // IEEE float
+	if _, err := DecodeWAV(bytes.NewReader(src)); err != ErrUnsupported {
+		t.Errorf("float: %v (expected %v)", err, ErrUnsupported)
+	}
+}
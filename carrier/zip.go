@@ -0,0 +1,140 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// ZIP signatures.
+const (
+	zipCentral   = "PK\x01\x02"
+	zipEnd       = "PK\x05\x06"
+	zip64End     = "PK\x06\x06"
+	zip64Locator = "PK\x06\x07"
+)
+
+// ErrSignature is returned when encoding a ZIP archive would write an
+// end of central directory signature into its comment, where readers
+// could take it for the real one.
+var ErrSignature = errors.New("samples forge a ZIP signature")
+
+// ZIP is a Carrier for ZIP archives.  Its samples are the bytes of the
+// archive comment and of each member's comment in the central
+// directory, none of which are covered by checksums or interpreted by
+// extractors, though some list them.  Everything else is left
+// untouched, so the encoded archive has the same members and length as
+// the original.
+//
+// Comments of members flagged as UTF-8 aren't exposed, since arbitrary
+// bytes would be invalid UTF-8.  Unlike tar, the format has no padding,
+// so comments are all the slack there is, and an archive without them,
+// as most are, has no samples.  Add a comment to the archive, as with
+// zip -z, to embed in it.
+type ZIP struct {
+	slack   *slack
+	samples []byte
+	// Offset of the end of central directory record.
+	end int
+}
+
+// zipEndRecord finds the end of central directory record, returning
+// its offset.
+func zipEndRecord(data []byte) (int, error) {
+	// The record is 22 bytes, followed by a comment of up to 65535.
+	for i := len(data) - 22; i >= 0 && i >= len(data)-22-65535; i-- {
+		if string(data[i:i+4]) != zipEnd {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint16(data[i+20:]))
+		if i+22+n <= len(data) {
+			return i, nil
+		}
+	}
+	return 0, ErrMalformed
+}
+
+// zipFits returns whether n bytes at off lie within data.
+func zipFits(data []byte, off, n uint64) bool {
+	return off <= uint64(len(data)) && n <= uint64(len(data))-off
+}
+
+// DecodeZIP decodes a ZIP archive from r, ready for embedding.
+func DecodeZIP(r io.Reader) (*ZIP, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	end, err := zipEndRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	rec := data[end:]
+	count := uint64(binary.LittleEndian.Uint16(rec[10:]))
+	off := uint64(binary.LittleEndian.Uint32(rec[16:]))
+	if count == 0xffff || off == 0xffffffff {
+		// ZIP64: a locator precedes the record, pointing at a
+		// ZIP64 end of central directory record.
+		if end < 20 || string(data[end-20:end-16]) != zip64Locator {
+			return nil, ErrMalformed
+		}
+		loc := binary.LittleEndian.Uint64(data[end-12:])
+		if !zipFits(data, loc, 56) || string(data[loc:loc+4]) != zip64End {
+			return nil, ErrMalformed
+		}
+		rec64 := data[loc:]
+		count = binary.LittleEndian.Uint64(rec64[32:])
+		off = binary.LittleEndian.Uint64(rec64[48:])
+	}
+
+	s := &slack{data: data}
+	for i := uint64(0); i < count; i++ {
+		if !zipFits(data, off, 46) || string(data[off:off+4]) != zipCentral {
+			return nil, ErrMalformed
+		}
+		h := data[off:]
+		n := uint64(binary.LittleEndian.Uint16(h[28:]))
+		m := uint64(binary.LittleEndian.Uint16(h[30:]))
+		k := uint64(binary.LittleEndian.Uint16(h[32:]))
+		start := off + 46 + n + m
+		if !zipFits(data, start, k) {
+			return nil, ErrMalformed
+		}
+		// General-purpose flag bit 11 marks the name and comment
+		// as UTF-8.
+		utf8 := binary.LittleEndian.Uint16(h[8:])&(1<<11) != 0
+		if k > 0 && !utf8 {
+			s.spans = append(s.spans, span{int(start), int(start + k)})
+		}
+		off = start + k
+	}
+	if n := int(binary.LittleEndian.Uint16(rec[20:])); n > 0 {
+		s.spans = append(s.spans, span{end + 22, end + 22 + n})
+	}
+	return &ZIP{slack: s, samples: s.gather(), end: end}, nil
+}
+
+// Samples returns the comments of the archive.
+func (z *ZIP) Samples() []byte {
+	return z.samples
+}
+
+// Encode writes the archive, with its current samples, to w.  Returns
+// ErrSignature, writing nothing, if the archive comment would contain
+// an end of central directory signature.
+func (z *ZIP) Encode(w io.Writer) error {
+	z.slack.scatter(z.samples)
+	// Readers search backward from the end for the record, so a
+	// signature in the comment, or overlapping its length, could be
+	// found first.
+	if bytes.LastIndex(z.slack.data[z.end:], []byte(zipEnd)) != 0 {
+		return ErrSignature
+	}
+	_, err := w.Write(z.slack.data)
+	return err
+}
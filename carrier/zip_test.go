@@ -0,0 +1,122 @@
+// chris 101826
+
+package carrier
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"archive/zip"
+	"crypto/rand"
+	"io/ioutil"
+
+	"chrispennello.com/go/steg"
+)
+
+// testZIPBytes returns an archive of compressed members with comments,
+// and an archive comment.
+func testZIPBytes(t *testing.T, comments []string, comment string) ([]byte, [][]byte) {
+	b := new(bytes.Buffer)
+	zw := zip.NewWriter(b)
+	var files [][]byte
+	for i, c := range comments {
+		p := make([]byte, 1000)
+		if _, err := rand.Read(p[:100]); err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:    string('a' + rune(i)),
+			Method:  zip.Deflate,
+			Comment: c,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, p)
+	}
+	if err := zw.SetComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes(), files
+}
+
+func TestZIP(t *testing.T) {
+	ctx := steg.NewPlaneCtx(1, 0xff)
+	// The last member's comment is flagged as UTF-8, and so isn't
+	// exposed.
+	comments := []string{strings.Repeat("x", 100), "", strings.Repeat("y", 50), "\u00e9t\u00e9"}
+	comment := strings.Repeat("z", 200)
+	src, files := testZIPBytes(t, comments, comment)
+
+	c, err := DecodeZIP(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Samples()) != strings.Join(comments[:3], "")+comment {
+		t.Fatalf("samples %q aren't the comments", c.Samples())
+	}
+
+	testEncodeUnchanged(t, src, decoders["zip"])
+	_, out := testRoundTrip(t, ctx, src, decoders["zip"])
+	if len(out) != len(src) {
+		t.Fatalf("length changed from %v to %v", len(src), len(out))
+	}
+
+	// The archive still reads the same, checksums and all.
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Fatalf("%v members (expected %v)", len(zr.File), len(files))
+	}
+	for i, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, files[i]) {
+			t.Fatalf("member %v changed", f.Name)
+		}
+		if i == 3 && f.Comment != comments[i] {
+			t.Errorf("UTF-8 comment changed to %q", f.Comment)
+		}
+	}
+}
+
+func TestZIPSignature(t *testing.T) {
+	src, _ := testZIPBytes(t, []string{"comment"}, strings.Repeat("z", 100))
+	c, err := DecodeZIP(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A signature in a member's comment precedes the real record, and
+	// is harmless.
+	copy(c.Samples(), zipEnd)
+	if err := c.Encode(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// One in the archive comment could be found instead of it.
+	copy(c.Samples()[50:], zipEnd+strings.Repeat("\x00", 18))
+	if err := c.Encode(ioutil.Discard); err != ErrSignature {
+		t.Errorf("forged signature: %v (expected %v)", err, ErrSignature)
+	}
+}
+
+func TestZIPMalformed(t *testing.T) {
+	src, _ := testZIPBytes(t, []string{"comment"}, "")
+	if _, err := DecodeZIP(bytes.NewReader(src[:len(src)-30])); err != ErrMalformed {
+		t.Errorf("truncated: %v (expected %v)", err, ErrMalformed)
+	}
+}
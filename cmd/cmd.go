@@ -4,12 +4,20 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 
+	"compress/flate"
+	"io/ioutil"
+
 	"chrispennello.com/go/steg"
-	"chrispennello.com/go/util/databox"
+	"chrispennello.com/go/steg/carrier"
+	"chrispennello.com/go/steg/fec"
+	"chrispennello.com/go/steg/seal"
 )
 
 // State represents the state of your command.  Fill it in by parsing
@@ -18,9 +26,60 @@ import (
 // it to nil; CarrierSize is not inspected in this case.
 //
 // InputSize or CarrierSize may be set to -1 to indicate that the input
-// or carrier is being streamed.  In the case of the input data being
-// streamed, if Box is being used, then all of the input data will be
-// read into memory by the databox library.
+// or carrier is being streamed.
+//
+// If Box is set, the input is embedded in steg's self-describing box
+// format, which records its length and how it was prepared, and which
+// verifies its integrity on extraction.  Streamed input is boxed as it
+// streams, with its length recorded at the end.  On extraction, the
+// Compress, Password, and FEC settings recorded in the box are used in
+// place of the state's, although the password itself must still be
+// provided for sealed input.
+//
+// If Format is set, it names a structured carrier format recognized by
+// package carrier.  The carrier (or, when extracting, the input) is
+// then decoded and read entirely into memory, and only its samples are
+// used for embedding; Offset is relative to the samples.
+//
+// If Compress is set, the input is compressed before muxing and
+// decompressed after extraction.
+//
+// If Password is non-nil, the input is sealed with a key derived from
+// it before muxing, and extracted data is opened with it; see package
+// seal.  Sealing reads all of the input into memory.  When extracting,
+// seal.ErrAuth is returned as is if the extracted data can't be
+// authenticated.
+//
+// If FEC is non-zero, the input, or with Box, the box body, is encoded
+// with that many Reed-Solomon parity bytes per codeword before muxing,
+// and corrupt bytes are corrected on extraction; see package fec.  If
+// the size of what's being encoded isn't known up front, it's read
+// entirely into memory.
+//
+// If Workers is greater than 1, chunks are muxed and extracted on that
+// many goroutines; see steg.ParallelMux.  It's ignored for atom sizes
+// above 3.
+//
+// If Probe is set, nothing is extracted; instead, the input is read
+// entirely into memory and searched for a box embedded at any atom
+// size and at any offset up to MaxOffset, with the state's bit planes,
+// and the parameters found are reported to the destination.  See
+// steg.Probe.  Format is respected.
+//
+// If InPlace is set, the carrier must be an io.ReadWriteSeeker, such as
+// a file opened for reading and writing.  The input is then embedded
+// into the carrier itself, and nothing is written to the destination;
+// see steg.NewInPlaceMux.  Workers is ignored, and Format must not be
+// set.
+//
+// If Context is non-nil, muxing and extraction stop with its error
+// once it's done.  If Progress is non-nil, it's called with progress
+// reports along the way, based on CarrierSize or, when extracting,
+// InputSize; see steg.Writer.Watch.
+//
+// If Stats is non-nil, it's filled in with what was embedded after
+// muxing, including the position of each flipped bit; see steg.Stats.
+// Muxing is then done on a single goroutine, regardless of Workers.
 type State struct {
 	Ctx         *steg.Ctx
 	Carrier     io.ReadCloser
@@ -29,11 +88,47 @@ type State struct {
 	InputSize   int64
 	Box         bool
 	Offset      int64
+	Format      string
+	Compress    bool
+	Password    []byte
+	FEC         int
+	Workers     int
+	Probe       bool
+	MaxOffset   int64
+	InPlace     bool
+	Context     context.Context
+	Progress    steg.ProgressFunc
+	Stats       *steg.Stats
+}
+
+// reader is implemented by steg.Reader and steg.ParallelReader.
+type reader interface {
+	io.Reader
+	Discard(n int64) error
+	Watch(cx context.Context, srcSize int64, f steg.ProgressFunc)
+}
+
+// muxer is implemented by steg.Mux and steg.ParallelMux.
+type muxer interface {
+	MuxContext(cx context.Context, carrierSize int64, f steg.ProgressFunc) error
+	CopyN(n int64) (int64, error)
+}
+
+// context returns the state's context, or a background one.
+func (s *State) context() context.Context {
+	if s.Context == nil {
+		return context.Background()
+	}
+	return s.Context
 }
 
 func extract(dst io.Writer, s *State) error {
 	var err error
-	sr := s.Ctx.NewReader(s.Input)
+	var sr reader = s.Ctx.NewReader(s.Input)
+	if s.Workers > 1 && s.Ctx.AtomSize() <= 3 {
+		sr = s.Ctx.NewParallelReader(s.Input, s.Workers)
+	}
+	sr.Watch(s.context(), s.InputSize, s.Progress)
 	if s.Offset != 0 {
 		err = sr.Discard(s.Offset)
 		if err != nil {
@@ -41,8 +136,50 @@ func extract(dst io.Writer, s *State) error {
 		}
 	}
 	r := io.Reader(sr)
+	compressed := s.Compress
+	sealed := s.Password != nil
 	if s.Box {
-		r = databox.NewUnmarshaller(r)
+		h, err := steg.ReadHeader(r)
+		if err != nil {
+			return fmt.Errorf("extract error: %v", err)
+		}
+		if h.AtomSize != s.Ctx.AtomSize() {
+			return fmt.Errorf("extract error: boxed with atom size %v", h.AtomSize)
+		}
+		if h.Flags&steg.FlagFEC != 0 {
+			if h.FECParity < 2 || h.FECParity > 127 {
+				return fmt.Errorf("extract error: %v", steg.ErrBadHeader)
+			}
+			r = fec.New(int(h.FECParity)).NewDecoder(r)
+		}
+		r = steg.NewBodyUnboxer(r, h)
+		compressed = h.Flags&steg.FlagCompressed != 0
+		sealed = h.Flags&steg.FlagSealed != 0
+		if sealed && s.Password == nil {
+			return errors.New("extract error: input is sealed; password required")
+		}
+	} else if s.FEC != 0 {
+		r = fec.New(s.FEC).NewDecoder(r)
+	}
+	if sealed {
+		msg, err := seal.Open(r, s.Password)
+		if err == seal.ErrAuth {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("extract error: %v", err)
+		}
+		if s.Box {
+			// Read the rest of the body to verify it.
+			_, err = io.Copy(ioutil.Discard, r)
+			if err != nil {
+				return fmt.Errorf("extract error: %v", err)
+			}
+		}
+		r = bytes.NewReader(msg)
+	}
+	if compressed {
+		r = flate.NewReader(r)
 	}
 	_, err = io.Copy(dst, r)
 	if err == steg.ErrShortRead {
@@ -56,17 +193,119 @@ func extract(dst io.Writer, s *State) error {
 	return nil
 }
 
+// probe searches the input for a box and reports what it finds.
+func probe(dst io.Writer, s *State) error {
+	input, err := ioutil.ReadAll(s.Input)
+	if err != nil {
+		return fmt.Errorf("probe error: %v", err)
+	}
+	p, err := steg.Probe(bytes.NewReader(input), s.Ctx.Planes(), s.MaxOffset)
+	if err != nil {
+		return fmt.Errorf("probe error: %v", err)
+	}
+	length := "streamed"
+	if p.Header.Length != -1 {
+		length = fmt.Sprint(p.Header.Length)
+	}
+	_, err = fmt.Fprintf(dst, "atomsize=%v offset=%v length=%v flags=%v fec=%v\n",
+		p.AtomSize, p.Offset, length, p.Header.Flags, p.Header.FECParity)
+	return err
+}
+
+// compress returns a reader of the compressed input.  Close it when
+// done to release the goroutine compressing into it.
+func compress(input io.Reader) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		fw, err := flate.NewWriter(pw, flate.DefaultCompression)
+		if err == nil {
+			_, err = io.Copy(fw, input)
+		}
+		if err == nil {
+			err = fw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
 func mux(dst io.Writer, s *State) error {
-	carrierStream := s.CarrierSize == -1
-	inputStream := s.InputSize == -1
 	carrierSize := s.CarrierSize
+	// -1 if unknown.
 	inputSize := s.InputSize
 	message := io.Reader(s.Input)
+	var flags steg.Flags
+	if s.Compress {
+		pr := compress(message)
+		defer pr.Close()
+		message = pr
+		inputSize = -1
+		flags |= steg.FlagCompressed
+	}
+	if s.Password != nil {
+		msg, err := ioutil.ReadAll(message)
+		if err != nil {
+			return fmt.Errorf("mux error: %v", err)
+		}
+		sealed, err := seal.Seal(msg, s.Password)
+		if err != nil {
+			return fmt.Errorf("mux error: %v", err)
+		}
+		message = bytes.NewReader(sealed)
+		inputSize = int64(len(sealed))
+		flags |= steg.FlagSealed
+	}
 	if s.Box {
-		message = databox.NewMarshaller(s.Input, s.InputSize)
-		inputSize += databox.HeaderSize
+		h := &steg.Header{
+			Version:  steg.BoxVersion,
+			AtomSize: s.Ctx.AtomSize(),
+			Flags:    flags,
+			Length:   inputSize,
+		}
+		if s.FEC != 0 {
+			h.Flags |= steg.FlagFEC
+			h.FECParity = uint8(s.FEC)
+		}
+		body := io.Reader(steg.NewBodyBoxer(message, h))
+		bodySize := h.BodySize()
+		if s.FEC != 0 {
+			code := fec.New(s.FEC)
+			body = code.NewEncoder(body, bodySize)
+			if bodySize != -1 {
+				bodySize = code.EncodedSize(bodySize)
+			}
+		}
+		message = io.MultiReader(bytes.NewReader(h.Marshal()), body)
+		inputSize = -1
+		if bodySize != -1 {
+			inputSize = steg.HeaderSize + bodySize
+		}
+	} else if s.FEC != 0 {
+		code := fec.New(s.FEC)
+		message = code.NewEncoder(message, inputSize)
+		if inputSize != -1 {
+			inputSize = code.EncodedSize(inputSize)
+		}
+	}
+	// sm is the muxer if it's a steg.Mux, which keeps stats.
+	var m muxer
+	var sm *steg.Mux
+	if s.InPlace {
+		f, ok := s.Carrier.(io.ReadWriteSeeker)
+		if !ok {
+			return errors.New("mux error: carrier can't be modified in place")
+		}
+		sm = s.Ctx.NewInPlaceMux(f, message)
+		m = sm
+	} else if s.Workers > 1 && s.Ctx.AtomSize() <= 3 && s.Stats == nil {
+		m = s.Ctx.NewParallelMux(dst, s.Carrier, message, s.Workers)
+	} else {
+		sm = s.Ctx.NewMux(dst, s.Carrier, message)
+		m = sm
+	}
+	if s.Stats != nil {
+		sm.RecordPositions()
 	}
-	m := s.Ctx.NewMux(dst, s.Carrier, message)
 	if s.Offset != 0 {
 		_, err := m.CopyN(s.Offset)
 		if err != nil {
@@ -74,13 +313,67 @@ func mux(dst io.Writer, s *State) error {
 		}
 		carrierSize -= s.Offset
 	}
-	if !inputStream && !carrierStream {
+	if inputSize != -1 && s.CarrierSize != -1 {
 		capacity := s.Ctx.Capacity(carrierSize)
 		if capacity < inputSize {
 			return fmt.Errorf("mux error: input size %v > capacity %v", inputSize, capacity)
 		}
 	}
-	err := m.Mux()
+	err := m.MuxContext(s.context(), s.CarrierSize, s.Progress)
+	if err != nil {
+		return fmt.Errorf("mux error: %v", err)
+	}
+	if s.Stats != nil {
+		*s.Stats = sm.Stats()
+	}
+	return nil
+}
+
+// extractCarrier decodes the structured input and extracts from its
+// samples.
+func extractCarrier(dst io.Writer, s *State) error {
+	c, err := carrier.Decode(s.Format, s.Input)
+	if err != nil {
+		return fmt.Errorf("extract error: %v", err)
+	}
+	ss := *s
+	ss.Input = ioutil.NopCloser(bytes.NewReader(c.Samples()))
+	ss.InputSize = int64(len(c.Samples()))
+	if s.Probe {
+		return probe(dst, &ss)
+	}
+	return extract(dst, &ss)
+}
+
+// bytesReaderCloser is a bytes.Reader with a no-op Close.  Unlike
+// ioutil.NopCloser, it keeps the reader seekable, as streamed chunks
+// need.
+type bytesReaderCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReaderCloser) Close() error {
+	return nil
+}
+
+// muxCarrier decodes the structured carrier, muxes into its samples,
+// and then encodes the modified carrier into the destination.
+func muxCarrier(dst io.Writer, s *State) error {
+	c, err := carrier.Decode(s.Format, s.Carrier)
+	if err != nil {
+		return fmt.Errorf("mux error: %v", err)
+	}
+	samples := c.Samples()
+	buf := bytes.NewBuffer(make([]byte, 0, len(samples)))
+	ss := *s
+	ss.Carrier = bytesReaderCloser{bytes.NewReader(samples)}
+	ss.CarrierSize = int64(len(samples))
+	err = mux(buf, &ss)
+	if err != nil {
+		return err
+	}
+	copy(samples, buf.Bytes())
+	err = c.Encode(dst)
 	if err != nil {
 		return fmt.Errorf("mux error: %v", err)
 	}
@@ -100,6 +393,12 @@ func Main(dst io.Writer, s *State) error {
 	}()
 
 	if s.Carrier == nil {
+		if s.Format != "" {
+			return extractCarrier(dst, s)
+		}
+		if s.Probe {
+			return probe(dst, s)
+		}
 		return extract(dst, s)
 	}
 
@@ -110,5 +409,11 @@ func Main(dst io.Writer, s *State) error {
 		}
 	}()
 
+	if s.Format != "" {
+		if s.InPlace {
+			return errors.New("mux error: structured carriers can't be modified in place")
+		}
+		return muxCarrier(dst, s)
+	}
 	return mux(dst, s)
 }
@@ -6,7 +6,11 @@
 // Steg is a command-line interface to the steganographic embedding
 // package steg of which it is a part.
 //
-// The atom size may be specified as 1, 2, or 3.  The default is 1.
+// The atom size may be specified as 1 through 7.  The default is 1.
+// Each increment of the atom size multiplies the carrier bytes used per
+// atom by 256, so sizes above 3 are only suitable for very large
+// carriers, like disk images.  For them, the carrier must be a regular
+// file, since it's read twice.
 //
 // Input can be provided either as a path, or from the default, standard
 // in.
@@ -28,16 +32,73 @@
 // provided by the carrier.  In this case, on extraction, you'll want
 // some way to know not to read more than was embedded.  A mechanism for
 // this is provided with the box flag.  This will enable the use of a
-// simple size-checking encapsulation format.  If you use it on write,
-// you'll want to use it on read as well.  Note that using the box flag
-// effectively increases the size of your input data.
+// self-describing box format that records the size of your input data,
+// how it was prepared, and a checksum to verify its integrity on
+// extraction.  If you use it on write, you'll want to use it on read
+// as well.  Note that using the box flag effectively increases the
+// size of your input data.  Input data from standard in is boxed as it
+// streams.
 //
-// If you are embedding input data into a carrier with the box flag, and
-// the input data is large, you may want to specify a path to the input
-// data explicitly instead of using standard in.  Otherwise, steg will
-// buffer all of the input data into memory so it can determine the size
-// for use with the size-checking encapsulation format.  Of course, if
-// the input data is small, then this isn't an issue.
+// By default, any bit of any carrier byte may be flipped to embed the
+// input data.  For carriers such as images or audio, where flipping a
+// high-order bit can be perceptible, the planes flag restricts
+// embedding to a mask of bit planes; e.g., 1 for just the
+// least-significant bit of each byte.  Fewer planes reduce the
+// capacity of the carrier accordingly.  If you use it on write, you'll
+// want to use the same mask on read as well.
+//
+// Rather than guessing at an offset to get past the headers of a
+// structured carrier, you may specify its format.  The carrier will be
+// decoded, and only the bytes that can safely be modified will be used
+// for embedding; e.g., for WAV audio, only the least-significant byte
+// of each PCM sample.  The encoded output will be a valid file of the
+// same format.  If you use it on write, you'll want to use it on read
+// as well.  Structured carriers are read entirely into memory.
+//
+// Embedded data is plaintext to anyone who knows the atom size and
+// offset used.  To prevent this, specify a password, or a path to a key
+// file whose contents are used as the password.  The input will be
+// encrypted and authenticated before embedding, and extracted data will
+// be authenticated and decrypted; steg will fail if the password is
+// wrong or the data was tampered with.  Sealing requires reading all of
+// the input into memory, and increases its size by a fixed overhead.
+//
+// The compress flag compresses the input data before embedding.  The
+// fec flag protects it against corruption of the carrier after
+// embedding with the given number of Reed-Solomon parity bytes for
+// every 255-byte codeword, up to 127; half as many corrupt bytes per
+// codeword can then be corrected on extraction.  Unless you know the
+// size of the data to be protected, it will be read into memory.  With
+// the box flag, the box records these options, so you needn't repeat
+// them on read; otherwise, you'll want to use the same ones.
+//
+// The workers flag sets the number of goroutines on which carrier
+// chunks are processed.  It defaults to 1, processing them serially; 0
+// means the number of CPUs.
+//
+// If you've forgotten the atom size and offset with which boxed data
+// was embedded, the probe flag will search for it instead of extracting
+// it: atom sizes 1, 2, and 3 are tried in turn at each offset up to the
+// maxoffset flag, with the given bit planes and format.  The parameters
+// found are written to standard out.  The input is read entirely into
+// memory, and probing for atom size 3 is slow.
+//
+// The inplace flag embeds the input into the carrier file itself,
+// rather than writing a modified copy to standard out; only the bytes
+// holding flipped bits are written back.  This saves disk space for
+// very large carriers, but a failure part way through leaves the
+// carrier partly modified, so the backup flag may name a path to which
+// the carrier is copied first.  It can't be used with a format.
+//
+// The progress flag draws a progress bar on standard error as the
+// carrier, or when extracting, the input, is consumed.  An interrupt
+// stops muxing or extraction part way through.
+//
+// The stats flag prints statistics of the embedding as JSON to
+// standard error after muxing: the number of chunks embedded in, the
+// number of carrier bits flipped, the number of chunks whose atom
+// already matched, the number of carrier bytes passed through, and the
+// position of each flipped bit.  With a format, these count samples.
 //
 // When embedding input data from a file, steg will check the effective
 // input data size against the capacity of the effective carrier size.
@@ -46,20 +107,40 @@
 //
 // Options are:
 //
-//	-atomsize=1: atom size (1, 2, or 3)
-//	-box=false:  use size-checking encapsulation format
+//	-atomsize=1: atom size (1 through 7)
+//	-backup="":  path to which to copy the carrier before modifying it in place
+//	-box=false:  use self-describing box format
 //	-carrier="": path to message carrier
+//	-compress=false: compress input
+//	-fec=0:      Reed-Solomon parity bytes per codeword; 0 for none
+//	-format="":  carrier format (bmp, gif, jpeg, pdf, png, pnm, tar, text, text+zw, wav, or zip); empty for raw
+//	-inplace=false: embed into the carrier file in place
 //	-input="-":  path to input; can be - for standard in
+//	-keyfile="": path to file containing the sealing password
+//	-maxoffset=1024: largest offset to probe
 //	-offset=0:   read/write offset
+//	-password="": password with which to seal the input
+//	-planes=255: mask of carrier bit planes to use
+//	-probe=false: probe input for atom size and offset of box
+//	-progress=false: show a progress bar on standard error
+//	-stats=false: print embedding statistics as JSON to standard error
+//	-workers=1:  number of worker goroutines; 0 for the number of CPUs
 //
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"runtime"
+	"strings"
+
+	"encoding/json"
+	"io/ioutil"
+	"os/signal"
 
 	"chrispennello.com/go/steg"
 	"chrispennello.com/go/steg/cmd"
@@ -79,13 +160,41 @@ func getFile(path string) (f *os.File, size int64) {
 	return f, fi.Size()
 }
 
-func getCarrier(path string) (carrier io.ReadCloser, size int64) {
+func getCarrier(path string, inPlace bool) (carrier io.ReadCloser, size int64) {
 	if path == "" {
 		return nil, -2
 	}
+	if inPlace {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return f, fi.Size()
+	}
 	return getFile(path)
 }
 
+// backup copies the file at path to backupPath, which mustn't exist.
+func backup(path, backupPath string) {
+	src, _ := getFile(path)
+	defer src.Close()
+	dst, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func getInput(path string) (input io.ReadCloser, size int64) {
 	if path == "-" {
 		return os.Stdin, -1
@@ -93,8 +202,38 @@ func getInput(path string) (input io.ReadCloser, size int64) {
 	return getFile(path)
 }
 
+func getKeyfile(path string) []byte {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(key) == 0 {
+		log.Fatalf("empty keyfile")
+	}
+	return key
+}
+
+// progressBar returns a ProgressFunc that draws a progress bar on
+// standard error, or just counts bytes if the size isn't known.
+func progressBar() steg.ProgressFunc {
+	const width = 40
+	return func(p steg.Progress) {
+		if p.CarrierSize <= 0 {
+			fmt.Fprintf(os.Stderr, "\r%v bytes, %v atoms", p.CarrierBytes, p.Atoms)
+			return
+		}
+		done := int(p.CarrierBytes * width / p.CarrierSize)
+		if done > width {
+			done = width
+		}
+		bar := strings.Repeat("=", done) + strings.Repeat(" ", width-done)
+		fmt.Fprintf(os.Stderr, "\r[%s] %3d%% %v atoms, %v bytes left",
+			bar, p.CarrierBytes*100/p.CarrierSize, p.Atoms, p.Remaining)
+	}
+}
+
 func init() {
-	atomSizeUsage := "atom size (1, 2, or 3)"
+	atomSizeUsage := "atom size (1 through 7)"
 	atomSize := flag.Uint("atomsize", 1, atomSizeUsage)
 
 	carrierUsage := "path to message carrier"
@@ -103,36 +242,159 @@ func init() {
 	inputUsage := "path to input; can be - for standard in"
 	input := flag.String("input", "-", inputUsage)
 
-	boxUsage := "use size-checking encapsulation format"
+	boxUsage := "use self-describing box format"
 	box := flag.Bool("box", false, boxUsage)
 
 	offsetUsage := "read/write offset"
 	offset := flag.Int64("offset", 0, offsetUsage)
 
+	formatUsage := "carrier format (bmp, gif, jpeg, pdf, png, pnm, tar, text, text+zw, wav, or zip); empty for raw"
+	format := flag.String("format", "", formatUsage)
+
+	planesUsage := "mask of carrier bit planes to use"
+	planes := flag.Uint("planes", 0xff, planesUsage)
+
+	compressUsage := "compress input"
+	compress := flag.Bool("compress", false, compressUsage)
+
+	fecUsage := "Reed-Solomon parity bytes per codeword; 0 for none"
+	fecParity := flag.Int("fec", 0, fecUsage)
+
+	passwordUsage := "password with which to seal the input"
+	password := flag.String("password", "", passwordUsage)
+
+	keyfileUsage := "path to file containing the sealing password"
+	keyfile := flag.String("keyfile", "", keyfileUsage)
+
+	probeUsage := "probe input for atom size and offset of box"
+	probe := flag.Bool("probe", false, probeUsage)
+
+	maxOffsetUsage := "largest offset to probe"
+	maxOffset := flag.Int64("maxoffset", 1024, maxOffsetUsage)
+
+	workersUsage := "number of worker goroutines; 0 for the number of CPUs"
+	workers := flag.Int("workers", 1, workersUsage)
+
+	inPlaceUsage := "embed into the carrier file in place"
+	inPlace := flag.Bool("inplace", false, inPlaceUsage)
+
+	backupUsage := "path to which to copy the carrier before modifying it in place"
+	backupPath := flag.String("backup", "", backupUsage)
+
+	progressUsage := "show a progress bar on standard error"
+	progress := flag.Bool("progress", false, progressUsage)
+
+	statsUsage := "print embedding statistics as JSON to standard error"
+	stats := flag.Bool("stats", false, statsUsage)
+
 	flag.Parse()
 
-	if *atomSize < 1 || *atomSize > 3 {
-		log.Fatalf("atom size must be 1, 2, or 3")
+	if *atomSize < 1 || *atomSize > 7 {
+		log.Fatalf("atom size must be between 1 and 7")
 	}
 
 	if *offset < 0 {
 		log.Fatalf("offset must be positive")
 	}
 
+	if *planes < 1 || *planes > 0xff {
+		log.Fatalf("planes must be between 1 and 255")
+	}
+
+	if *fecParity != 0 && (*fecParity < 2 || *fecParity > 127) {
+		log.Fatalf("fec must be 0, or between 2 and 127")
+	}
+
+	if *password != "" && *keyfile != "" {
+		log.Fatalf("password and keyfile are mutually exclusive")
+	}
+
+	if *maxOffset < 0 {
+		log.Fatalf("max offset must be positive")
+	}
+
+	if *probe && *carrier != "" {
+		log.Fatalf("probe requires no carrier")
+	}
+
+	if *inPlace && *carrier == "" {
+		log.Fatalf("inplace requires a carrier")
+	}
+
+	if *inPlace && *format != "" {
+		log.Fatalf("inplace and format are mutually exclusive")
+	}
+
+	if *backupPath != "" && !*inPlace {
+		log.Fatalf("backup requires inplace")
+	}
+
+	if *stats && *carrier == "" {
+		log.Fatalf("stats requires a carrier")
+	}
+
+	if *workers < 0 {
+		log.Fatalf("workers must not be negative")
+	}
+	if *workers == 0 {
+		*workers = runtime.NumCPU()
+	}
+
 	state = new(cmd.State)
-	state.Ctx = steg.NewCtx(uint8(*atomSize))
-	state.Carrier, state.CarrierSize = getCarrier(*carrier)
+	state.Ctx = steg.NewPlaneCtx(uint8(*atomSize), byte(*planes))
+	if *backupPath != "" {
+		backup(*carrier, *backupPath)
+	}
+	state.Carrier, state.CarrierSize = getCarrier(*carrier, *inPlace)
 	state.Input, state.InputSize = getInput(*input)
 	state.Box = *box
 	state.Offset = *offset
+	state.Format = *format
+	state.Compress = *compress
+	state.FEC = *fecParity
+	state.Workers = *workers
+	state.Probe = *probe
+	state.MaxOffset = *maxOffset
+	state.InPlace = *inPlace
+	if *progress {
+		state.Progress = progressBar()
+	}
+	if *stats {
+		state.Stats = new(steg.Stats)
+	}
+	if *password != "" {
+		state.Password = []byte(*password)
+	}
+	if *keyfile != "" {
+		state.Password = getKeyfile(*keyfile)
+	}
 }
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix(fmt.Sprintf("%s: ", os.Args[0]))
+
+	cx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+	state.Context = cx
+
 	err := cmd.Main(os.Stdout, state)
+	if state.Progress != nil {
+		fmt.Fprintln(os.Stderr)
+	}
 	if err != nil {
 		log.Print(err)
 		os.Exit(1)
 	}
+	if state.Stats != nil {
+		err = json.NewEncoder(os.Stderr).Encode(state.Stats)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
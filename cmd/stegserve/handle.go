@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"chrispennello.com/go/steg/cmd"
+	"chrispennello.com/go/steg/seal"
 )
 
 // HTTP handler function initialization common to both local servers as
@@ -27,30 +28,37 @@ func errorResponse(w http.ResponseWriter, status int, err error) {
 	}
 }
 
-func apiHandler(w http.ResponseWriter, req *http.Request) {
-	s, err := parseApi(req)
-	if err != nil {
-		errorResponse(w, 400, err)
+// mainResponse runs the command, responding with an appropriate error
+// status on failure.  The command stops if the client goes away.
+func mainResponse(w http.ResponseWriter, req *http.Request, s *cmd.State) {
+	s.Context = req.Context()
+	err := cmd.Main(w, s)
+	if err == seal.ErrAuth {
+		errorResponse(w, 403, err)
 		return
 	}
-	err = cmd.Main(w, s)
 	if err != nil {
 		errorResponse(w, 500, err)
 		return
 	}
 }
 
-func mimeHandler(w http.ResponseWriter, req *http.Request) {
-	s, err := parseForm(req)
+func apiHandler(w http.ResponseWriter, req *http.Request) {
+	s, err := parseApi(req)
 	if err != nil {
 		errorResponse(w, 400, err)
 		return
 	}
-	err = cmd.Main(w, s)
+	mainResponse(w, req, s)
+}
+
+func mimeHandler(w http.ResponseWriter, req *http.Request) {
+	s, err := parseForm(req)
 	if err != nil {
-		errorResponse(w, 500, err)
+		errorResponse(w, 400, err)
 		return
 	}
+	mainResponse(w, req, s)
 }
 
 func indexHandler(w http.ResponseWriter, req *http.Request) {
@@ -15,26 +15,42 @@
 // documentation of the steg command for a fuller explanation of these
 // arguments.
 //
-//	X-Steg-Atom-Size	defaults to 1; can be 1, 2, or 3
+//	X-Steg-Atom-Size	defaults to 1; can be 1 through 7, but
+//				only 1, 2, or 3 with a carrier and
+//				no format
 //	X-Steg-Box		defaults to false;
 //				accepts values recognized by
 //				strconv.ParseBool
 //	X-Steg-Carrier		optional; valid URL
+//	X-Steg-Format		optional; carrier format (bmp, gif, jpeg, pdf, png, pnm, tar, text, text+zw, wav, or zip)
 //	X-Steg-Input		defaults to use the request body;
 //				valid URL
 //	X-Steg-Offset		defaults to 0; read/write offset
+//	X-Steg-Password		optional; password with which to seal
+//				the input
+//	X-Steg-Planes		defaults to 255; mask of carrier bit
+//				planes to use
 //
 // /mime takes the following form-data arguments.  See the GoDoc
 // documentation of the steg command for a fuller explanation of these
 // arguments.
 //
-//	atom-size	required; can be 1, 2, or 3
+//	atom-size	required; can be 1 through 7, but only 1,
+//			2, or 3 with a carrier and no format
 //	box		defaults to false; "on" for true,
 //			also accepts values recognized by
 //			strconv.ParseBool
 //	carrier		optional; valid URL or file upload
+//	format		optional; carrier format (bmp, gif, jpeg, pdf, png, pnm, tar, text, text+zw, wav, or zip)
 //	input		required; valid URL or file upload
 //	offset		defaults to 0; read/write offset
+//	password	optional; password with which to seal the
+//			input
+//	planes		defaults to 255; mask of carrier bit planes
+//			to use
+//
+// If extracted data can't be authenticated with the given password,
+// the endpoints respond with status 403.
 //
 // This command provides a demonstration of the sort of network
 // proxying interface one might implement to provide remote
@@ -73,12 +73,25 @@ func parseAtomSize(atomSizeStr string) (uint8, error) {
 	if err != nil {
 		return 0, errors.New("invalid atom size value")
 	}
-	if atomSize < 1 || atomSize > 3 {
-		return 0, errors.New("atom size must be 1, 2, or 3")
+	if atomSize < 1 || atomSize > 7 {
+		return 0, errors.New("atom size must be between 1 and 7")
 	}
 	return uint8(atomSize), nil
 }
 
+// checkAtomSize rejects atom sizes above 3 when muxing into a raw
+// carrier.  Chunks that big are streamed, which needs a seekable
+// carrier, and neither request bodies nor fetched URLs are; see
+// steg.ErrUnseekable.  Extraction, and muxing into a formatted carrier,
+// whose samples are decoded into memory and muxed into from there,
+// accept any atom size.
+func checkAtomSize(atomSize uint8, muxing bool, format string) error {
+	if atomSize > 3 && muxing && format == "" {
+		return errors.New("atom size must be 1, 2, or 3 to mux into a raw carrier")
+	}
+	return nil
+}
+
 func parseBox(boxStr string) (bool, error) {
 	box, err := strconv.ParseBool(boxStr)
 	if err != nil {
@@ -98,6 +111,17 @@ func parseOffset(offsetStr string) (int64, error) {
 	return offset, nil
 }
 
+func parsePlanes(planesStr string) (byte, error) {
+	planes, err := strconv.ParseUint(planesStr, 0, 8)
+	if err != nil {
+		return 0, errors.New("invalid planes value")
+	}
+	if planes < 1 {
+		return 0, errors.New("planes must be between 1 and 255")
+	}
+	return byte(planes), nil
+}
+
 func parseApi(req *http.Request) (s *cmd.State, err error) {
 	atomSizeStr := getHeader(req, "Atom-Size")
 	if atomSizeStr == "" {
@@ -150,8 +174,29 @@ func parseApi(req *http.Request) (s *cmd.State, err error) {
 		return nil, err
 	}
 
+	planesStr := getHeader(req, "Planes")
+	if planesStr == "" {
+		planesStr = "255"
+	}
+	planes, err := parsePlanes(planesStr)
+	if err != nil {
+		return nil, err
+	}
+
+	format := getHeader(req, "Format")
+	password := getHeader(req, "Password")
+
+	err = checkAtomSize(atomSize, carrier != nil, format)
+	if err != nil {
+		return nil, err
+	}
+
 	s = new(cmd.State)
-	s.Ctx = steg.NewCtx(atomSize)
+	s.Ctx = steg.NewPlaneCtx(atomSize, planes)
+	s.Format = format
+	if password != "" {
+		s.Password = []byte(password)
+	}
 	s.Carrier, s.CarrierSize, err = getCarrier(carrier)
 	if err != nil {
 		return nil, err
@@ -242,6 +287,7 @@ func parseForm(req *http.Request) (s *cmd.State, err error) {
 	s = new(cmd.State)
 
 	var atomSize uint8
+	var planes byte = 0xff
 	var carrier *url.URL
 	var carrierReader io.ReadCloser
 	var input *url.URL
@@ -310,6 +356,38 @@ func parseForm(req *http.Request) (s *cmd.State, err error) {
 				s.Box = box
 			}
 
+		case "format":
+			{
+				formatBytes, err := ioutil.ReadAll(part)
+				if err != nil {
+					return nil, err
+				}
+				s.Format = string(formatBytes)
+			}
+
+		case "password":
+			{
+				passwordBytes, err := ioutil.ReadAll(part)
+				if err != nil {
+					return nil, err
+				}
+				if len(passwordBytes) != 0 {
+					s.Password = passwordBytes
+				}
+			}
+
+		case "planes":
+			{
+				planesBytes, err := ioutil.ReadAll(part)
+				if err != nil {
+					return nil, err
+				}
+				planes, err = parsePlanes(string(planesBytes))
+				if err != nil {
+					return nil, err
+				}
+			}
+
 		case "offset":
 			{
 				offsetBytes, err := ioutil.ReadAll(part)
@@ -328,7 +406,11 @@ func parseForm(req *http.Request) (s *cmd.State, err error) {
 	if atomSize == 0 {
 		return nil, errors.New("atom-size required")
 	}
-	s.Ctx = steg.NewCtx(atomSize)
+	err = checkAtomSize(atomSize, carrierReader != nil || carrier != nil, s.Format)
+	if err != nil {
+		return nil, err
+	}
+	s.Ctx = steg.NewPlaneCtx(atomSize, planes)
 
 	if carrierReader != nil {
 		s.Carrier, s.CarrierSize = carrierReader, -1
@@ -160,7 +160,8 @@
 // the resulting message bits that Bob reads will have that bit, and
 // only that bit flipped, by the reading scheme outlined earlier.
 //
-// This is the algorithm that package steg implements.
+// This is the algorithm that package steg implements, except that
+// where the message bits already match, it flips no bit at all.
 //
 // Implementation
 //
@@ -175,10 +176,47 @@
 //	   1B           32B
 //	   2B          8KiB
 //	   3B          2MiB
+//	   4B        512MiB
+//	   5B        128GiB
+//	   6B         32TiB
+//	   7B          8PiB
+//
+// Chunks bigger than 64KiB are streamed through a window rather than
+// held in memory, with two exceptions.  Embedding in a streamed chunk
+// needs either to patch the flipped bit in the destination or to read
+// the chunk twice, so if the carrier isn't seekable, a Writer holds
+// each chunk in memory after all: 2MiB at atom size 3, more with fewer
+// planes.  Above atom size 3, the carrier must be seekable; see
+// Writer.Write.  And wet paper embedding always holds a whole chunk,
+// and so is limited to atom sizes up to 3; see NewWetWriter.
+//
+// By default, all eight bits of every carrier byte are carrier bits,
+// and so the bit flipped to embed an atom may well be a high-order one.
+// For carriers like images and audio, where such a flip can be
+// perceptible, a context may instead be restricted to a subset of bit
+// planes, e.g., just the least-significant bit of each byte.  A chunk
+// then spans correspondingly more carrier bytes: with a single plane,
+// eight times as many as in the table above.
 //
 // First, create a context with an atom size.  Then, create readers,
-// writers, and muxes from the context.  By design, the implementation
-// makes no effort to be aware of the character of the carrier data.
+// writers, and muxes from the context.  These embed atoms in
+// consecutive chunks from the start of the carrier.  Alternatively,
+// given a key and the size of the carrier up front, a Schedule spreads
+// them across the whole carrier in a pseudo-random order.  By design,
+// the implementation makes no effort to be aware of the character of
+// the carrier data.  For structured carriers, such as PNG images, see
+// the adapters in package carrier.  Embedded data is not itself
+// concealed from anyone who knows the atom size used; to keep it
+// confidential, see package seal.  Nor is it protected against
+// corruption of the carrier after muxing; for error correction, see
+// package fec.  Data embedded in a box (see Header) can be found
+// without knowing the atom size or offset used; see Probe.  For a
+// finer trade-off between capacity and the number of carrier bits
+// flipped than the atom size allows, see MatrixCtx.  To keep some
+// carrier bits from being flipped at all, see NewWetWriter.  To
+// extract only part of a large message, see SeekReader.  To cancel
+// long runs or report their progress, see Mux.MuxContext and
+// Reader.Watch.
 //
 // References
 //
@@ -0,0 +1,255 @@
+// chris 101826
+
+// Package fec implements a forward error correction layer for messages
+// to be steganographically embedded.
+//
+// A single flipped carrier bit after muxing, whether from
+// recompression, a transmission error, or tampering, silently corrupts
+// the extracted atom.  Encoding a message with Reed-Solomon coding
+// before muxing it allows a number of such corrupted bytes to be
+// detected and repaired on extraction.
+//
+// The message is split into blocks, each of which is followed by a
+// configurable number of parity bytes.  A full codeword is 255 bytes;
+// with p parity bytes, it carries 255 - p message bytes, and up to p/2
+// corrupt bytes in it can be corrected.  The encoded stream begins with
+// a codeword of its own containing the message length, so that the
+// decoder knows where the message ends, regardless of whatever carrier
+// data follows it.
+package fec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"encoding/binary"
+
+	"chrispennello.com/go/steg"
+)
+
+// ErrUncorrectable is returned by Decoder.Read when a codeword has too
+// many errors to be corrected.
+var ErrUncorrectable = errors.New("uncorrectable errors")
+
+const (
+	// Maximum codeword size.
+	blockSize = 255
+	// Size of the length in the leading codeword.
+	lengthSize = 8
+)
+
+// A Code is a Reed-Solomon code with a fixed number of parity bytes per
+// codeword.
+type Code struct {
+	parity int
+	gen    []byte
+}
+
+// An Encoder reads a message and returns it encoded with a Code.
+// Implements io.Reader.
+type Encoder struct {
+	c   *Code
+	msg io.Reader
+	// Encoded bytes not yet returned.
+	buf []byte
+	// Message bytes remaining to be encoded.
+	rem int64
+}
+
+// A Decoder reads data encoded with a Code, correcting errors, and
+// returns the original message.  Implements io.Reader.
+type Decoder struct {
+	c   *Code
+	src io.Reader
+	// Decoded bytes not yet returned.
+	buf []byte
+	// Message bytes remaining to be decoded; -1 before the length
+	// has been read.
+	rem       int64
+	corrected int
+	err       error
+}
+
+// New returns a Code with the given number of parity bytes per
+// codeword, able to correct half as many corrupt bytes per codeword.
+// Panics if parity is not between 2 and 127.  (Beyond 127, parity
+// bytes would outnumber message bytes in a full codeword.)
+func New(parity int) *Code {
+	if parity < 2 || parity > 127 {
+		panic("inappropriate parity size")
+	}
+	return &Code{parity: parity, gen: generator(parity)}
+}
+
+// dataSize returns the number of message bytes in a full codeword.
+func (c *Code) dataSize() int {
+	return blockSize - c.parity
+}
+
+// EncodedSize returns the size of the encoding of a message of the
+// given size, in bytes.
+func (c *Code) EncodedSize(messageSize int64) int64 {
+	k := int64(c.dataSize())
+	size := int64(lengthSize + c.parity)
+	size += messageSize / k * blockSize
+	if rem := messageSize % k; rem != 0 {
+		size += rem + int64(c.parity)
+	}
+	return size
+}
+
+// Capacity returns the largest message a carrier of the given size can
+// embed with the given context once encoded, in bytes.
+func (c *Code) Capacity(ctx *steg.Ctx, carrierSize int64) int64 {
+	avail := ctx.Capacity(carrierSize) - int64(lengthSize+c.parity)
+	if avail <= 0 {
+		return 0
+	}
+	size := avail / blockSize * int64(c.dataSize())
+	if rem := avail%blockSize - int64(c.parity); rem > 0 {
+		size += rem
+	}
+	return size
+}
+
+// encode returns the codeword for the data.
+func (c *Code) encode(data []byte) []byte {
+	codeword := make([]byte, len(data)+c.parity)
+	copy(codeword, data)
+	rsEncode(c.gen, data, codeword[len(data):])
+	return codeword
+}
+
+// NewEncoder returns an Encoder, ready to encode the message of the
+// given size.  If the size is -1, the message is read entirely into
+// memory to determine it.
+func (c *Code) NewEncoder(msg io.Reader, size int64) *Encoder {
+	e := &Encoder{c: c, msg: msg, rem: size}
+	if size == -1 {
+		buf := new(bytes.Buffer)
+		_, err := buf.ReadFrom(msg)
+		if err != nil {
+			// Surface the error on the first Read.
+			e.msg = errReader{err}
+		} else {
+			e.msg = buf
+		}
+		e.rem = int64(buf.Len())
+	}
+	length := make([]byte, lengthSize)
+	binary.BigEndian.PutUint64(length, uint64(e.rem))
+	e.buf = c.encode(length)
+	return e
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// Read reads encoded bytes.  Returns io.ErrUnexpectedEOF if the message
+// ends before its declared size.
+func (e *Encoder) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(e.buf) == 0 {
+			if e.rem == 0 {
+				return n, io.EOF
+			}
+			k := int64(e.c.dataSize())
+			if k > e.rem {
+				k = e.rem
+			}
+			data := make([]byte, k)
+			_, err = io.ReadFull(e.msg, data)
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+			e.rem -= k
+			e.buf = e.c.encode(data)
+		}
+		nn := copy(p[n:], e.buf)
+		e.buf = e.buf[nn:]
+		n += nn
+	}
+	return n, nil
+}
+
+// NewDecoder returns a Decoder, ready to decode a message encoded with
+// the code from the source.
+func (c *Code) NewDecoder(src io.Reader) *Decoder {
+	return &Decoder{c: c, src: src, rem: -1}
+}
+
+// decode reads and corrects the next codeword of n data bytes.
+func (d *Decoder) decode(n int) ([]byte, error) {
+	codeword := make([]byte, n+d.c.parity)
+	_, err := io.ReadFull(d.src, codeword)
+	if err != nil {
+		return nil, err
+	}
+	corrected := rsDecode(codeword, d.c.parity)
+	if corrected < 0 {
+		return nil, ErrUncorrectable
+	}
+	d.corrected += corrected
+	return codeword[:n], nil
+}
+
+// Read reads decoded message bytes, correcting errors.  Returns io.EOF
+// at the end of the message, without reading beyond its encoding in
+// the source.
+//
+// Returns ErrUncorrectable if a codeword had too many errors to be
+// corrected.  Can return io.EOF or io.ErrUnexpectedEOF from the source
+// if it ends before the encoded message.
+func (d *Decoder) Read(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.rem == -1 {
+		length, err := d.decode(lengthSize)
+		if err != nil {
+			d.err = err
+			return 0, err
+		}
+		d.rem = int64(binary.BigEndian.Uint64(length))
+		if d.rem < 0 {
+			d.err = ErrUncorrectable
+			return 0, d.err
+		}
+	}
+	for n < len(p) {
+		if len(d.buf) == 0 {
+			if d.rem == 0 {
+				return n, io.EOF
+			}
+			k := int64(d.c.dataSize())
+			if k > d.rem {
+				k = d.rem
+			}
+			d.buf, err = d.decode(int(k))
+			if err != nil {
+				d.err = err
+				return n, err
+			}
+			d.rem -= k
+		}
+		nn := copy(p[n:], d.buf)
+		d.buf = d.buf[nn:]
+		n += nn
+	}
+	return n, nil
+}
+
+// Corrected returns the number of corrupt bytes the decoder has
+// repaired so far.
+func (d *Decoder) Corrected() int {
+	return d.corrected
+}
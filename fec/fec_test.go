@@ -0,0 +1,149 @@
+// chris 101826
+
+package fec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"io/ioutil"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+
+	"chrispennello.com/go/steg"
+)
+
+func testEncodeDecode(t *testing.T, parity, msgLen int, stream bool) {
+	c := New(parity)
+	msg := make([]byte, msgLen)
+	if _, err := cryptorand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(msgLen)
+	if stream {
+		size = -1
+	}
+	encoded, err := ioutil.ReadAll(c.NewEncoder(bytes.NewReader(msg), size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(encoded)) != c.EncodedSize(int64(msgLen)) {
+		t.Fatalf("encoded size %v (expected %v)", len(encoded), c.EncodedSize(int64(msgLen)))
+	}
+
+	// Corrupt as many bytes per codeword as can be corrected.
+	expect := 0
+	n := lengthSize + parity
+	for off := 0; off < len(encoded); off += n {
+		if off != 0 {
+			n = blockSize
+		}
+		if off+n > len(encoded) {
+			n = len(encoded) - off
+		}
+		for _, j := range mathrand.Perm(n)[:parity/2] {
+			encoded[off+j] ^= byte(mathrand.Intn(255) + 1)
+			expect++
+		}
+	}
+
+	// Trailing data should be left alone.
+	src := bytes.NewReader(append(encoded, "trailing"...))
+	d := c.NewDecoder(src)
+	test, err := ioutil.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to decode %v bytes, parity = %v", msgLen, parity)
+	}
+	if d.Corrected() != expect {
+		t.Errorf("corrected %v bytes (expected %v)", d.Corrected(), expect)
+	}
+	if src.Len() != len("trailing") {
+		t.Errorf("read %v bytes past encoded message", len("trailing")-src.Len())
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	testEncodeDecode(t, 2, 0, false)
+	testEncodeDecode(t, 16, 1000, false)
+	testEncodeDecode(t, 16, 1000, true)
+	testEncodeDecode(t, 32, 223*4, false)
+	for i := 0; i < 20; i++ {
+		testEncodeDecode(t, mathrand.Intn(126)+2, mathrand.Intn(2000), i%2 == 0)
+	}
+}
+
+func TestUncorrectable(t *testing.T) {
+	c := New(4)
+	encoded, err := ioutil.ReadAll(c.NewEncoder(bytes.NewReader(make([]byte, 500)), 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 110; i++ {
+		encoded[i] ^= 0xff
+	}
+	_, err = ioutil.ReadAll(c.NewDecoder(bytes.NewReader(encoded)))
+	if err != ErrUncorrectable {
+		t.Errorf("err = %v (expected %v)", err, ErrUncorrectable)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	ctx := steg.NewCtx(1)
+	for i := 0; i < 100; i++ {
+		c := New(mathrand.Intn(126) + 2)
+		carrierSize := int64(mathrand.Intn(100000))
+		capacity := c.Capacity(ctx, carrierSize)
+		raw := ctx.Capacity(carrierSize)
+		if capacity > 0 && c.EncodedSize(capacity) > raw {
+			t.Fatalf("encoded capacity %v > %v", c.EncodedSize(capacity), raw)
+		}
+		if c.EncodedSize(capacity+1) <= raw {
+			t.Fatalf("capacity %v not maximal for %v", capacity, raw)
+		}
+	}
+}
+
+func TestMuxFlips(t *testing.T) {
+	ctx := steg.NewCtx(1)
+	c := New(16)
+	// 32-byte chunks at atom size 1.
+	carrierBytes := make([]byte, 128*32)
+	if _, err := cryptorand.Read(carrierBytes); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, c.Capacity(ctx, int64(len(carrierBytes))))
+	if _, err := cryptorand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	enc := c.NewEncoder(bytes.NewReader(msg), int64(len(msg)))
+	err := ctx.NewMux(dst, bytes.NewReader(carrierBytes), enc).Mux()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a bit in each of a few random chunks with embedded
+	// data, corrupting their atoms.
+	out := dst.Bytes()
+	nchunks := int(c.EncodedSize(int64(len(msg))))
+	for _, i := range mathrand.Perm(nchunks)[:5] {
+		out[i*32+mathrand.Intn(32)] ^= 1 << uint(mathrand.Intn(8))
+	}
+
+	d := c.NewDecoder(ctx.NewReader(bytes.NewReader(out)))
+	test := make([]byte, len(msg))
+	if _, err := io.ReadFull(d, test); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Error("failed to recover message")
+	}
+	if d.Corrected() != 5 {
+		t.Errorf("corrected %v bytes (expected 5)", d.Corrected())
+	}
+}
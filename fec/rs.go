@@ -0,0 +1,197 @@
+// chris 101826 Reed-Solomon coding over GF(2^8).
+
+package fec
+
+// Arithmetic in GF(2^8) with the primitive polynomial
+// x^8 + x^4 + x^3 + x^2 + 1, generated by alpha = 2.
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// Doubled so that sums of logs needn't be reduced mod 255.
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element.
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// polyEval evaluates the polynomial p, coefficients in increasing order
+// of degree, at x.
+func polyEval(p []byte, x byte) byte {
+	y := byte(0)
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+// generator returns the generator polynomial of a code with the given
+// number of parity symbols, (x - a^0)(x - a^1)...(x - a^(parity-1)),
+// coefficients in decreasing order of degree, leading 1 omitted.
+func generator(parity int) []byte {
+	g := make([]byte, parity+1)
+	g[0] = 1
+	for i := 0; i < parity; i++ {
+		// Multiply by (x + a^i).
+		for j := i + 1; j > 0; j-- {
+			g[j] ^= gfMul(g[j-1], gfExp[i])
+		}
+	}
+	return g[1:]
+}
+
+// rsEncode computes the parity symbols of data into parity, which must
+// be len(gen) long.  A codeword is the data followed by the parity,
+// read as a polynomial with the first byte the coefficient of highest
+// degree.
+func rsEncode(gen, data, parity []byte) {
+	for i := range parity {
+		parity[i] = 0
+	}
+	// Polynomial division by the generator, as an LFSR.
+	for _, d := range data {
+		f := d ^ parity[0]
+		copy(parity, parity[1:])
+		parity[len(parity)-1] = 0
+		if f != 0 {
+			for j, g := range gen {
+				parity[j] ^= gfMul(f, g)
+			}
+		}
+	}
+}
+
+// rsDecode corrects up to nparity/2 symbol errors in the codeword in
+// place, returning the number corrected, or -1 if there were too many
+// errors to correct, in which case the codeword is left unmodified.
+func rsDecode(codeword []byte, nparity int) int {
+	n := len(codeword)
+
+	// Syndromes.  All zero iff there are no (detectable) errors.
+	s := make([]byte, nparity)
+	clean := true
+	for i := range s {
+		x := gfExp[i]
+		y := byte(0)
+		for _, c := range codeword {
+			y = gfMul(y, x) ^ c
+		}
+		s[i] = y
+		if y != 0 {
+			clean = false
+		}
+	}
+	if clean {
+		return 0
+	}
+
+	// Berlekamp-Massey for the error locator polynomial, lambda,
+	// coefficients in increasing order of degree.
+	lambda := make([]byte, nparity+1)
+	lambda[0] = 1
+	prev := make([]byte, nparity+1)
+	prev[0] = 1
+	l, m, b := 0, 1, byte(1)
+	for k := 0; k < nparity; k++ {
+		d := s[k]
+		for i := 1; i <= l; i++ {
+			d ^= gfMul(lambda[i], s[k-i])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		f := gfDiv(d, b)
+		next := append([]byte(nil), lambda...)
+		for i := 0; i+m <= nparity; i++ {
+			next[i+m] ^= gfMul(f, prev[i])
+		}
+		if 2*l <= k {
+			prev = lambda
+			l = k + 1 - l
+			b = d
+			m = 1
+		} else {
+			m++
+		}
+		lambda = next
+	}
+	if 2*l > nparity {
+		return -1
+	}
+	lambda = lambda[:l+1]
+
+	// Error evaluator polynomial, omega = s * lambda mod x^nparity.
+	omega := make([]byte, nparity)
+	for i := range omega {
+		for j := 0; j <= i && j <= l; j++ {
+			omega[i] ^= gfMul(lambda[j], s[i-j])
+		}
+	}
+
+	// Formal derivative of lambda.  In characteristic 2, only the
+	// odd terms survive.
+	dlambda := make([]byte, l)
+	for i := 1; i <= l; i += 2 {
+		dlambda[i-1] = lambda[i]
+	}
+
+	// Chien search for the roots of lambda, the inverses of the
+	// error locations, and Forney for the error values.
+	var pos []int
+	var val []byte
+	for j := 0; j < n; j++ {
+		// The symbol at j is the coefficient of x^(n-1-j).
+		p := n - 1 - j
+		xinv := gfExp[(255-p)%255]
+		if polyEval(lambda, xinv) != 0 {
+			continue
+		}
+		den := polyEval(dlambda, xinv)
+		if den == 0 {
+			return -1
+		}
+		pos = append(pos, j)
+		val = append(val, gfMul(gfExp[p], gfDiv(polyEval(omega, xinv), den)))
+	}
+	if len(pos) != l {
+		// Roots outside of the codeword; too many errors.
+		return -1
+	}
+	for i, j := range pos {
+		codeword[j] ^= val[i]
+	}
+	return l
+}
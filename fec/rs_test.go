@@ -0,0 +1,68 @@
+// chris 101826
+
+package fec
+
+import (
+	"bytes"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatalf("%v * %v^-1 != 1", a, a)
+		}
+		b := byte(mathrand.Intn(255) + 1)
+		if gfDiv(gfMul(byte(a), b), b) != byte(a) {
+			t.Fatalf("%v * %v / %v != %v", a, b, b, a)
+		}
+	}
+}
+
+func testRS(t *testing.T, n, nparity, nerrors int) {
+	gen := generator(nparity)
+	codeword := make([]byte, n)
+	if _, err := cryptorand.Read(codeword[:n-nparity]); err != nil {
+		t.Fatal(err)
+	}
+	rsEncode(gen, codeword[:n-nparity], codeword[n-nparity:])
+	if rsDecode(append([]byte(nil), codeword...), nparity) != 0 {
+		t.Fatalf("clean codeword not recognized, n = %v, parity = %v", n, nparity)
+	}
+
+	damaged := append([]byte(nil), codeword...)
+	for _, j := range mathrand.Perm(n)[:nerrors] {
+		damaged[j] ^= byte(mathrand.Intn(255) + 1)
+	}
+	corrected := rsDecode(damaged, nparity)
+	if nerrors <= nparity/2 {
+		if corrected != nerrors {
+			t.Fatalf("corrected %v errors (expected %v), n = %v, parity = %v",
+				corrected, nerrors, n, nparity)
+		}
+		if !bytes.Equal(damaged, codeword) {
+			t.Fatalf("failed to correct codeword")
+		}
+		return
+	}
+	// Past the correction capacity, rsDecode may still "correct"
+	// to the wrong codeword, but it must at least be a codeword.
+	if corrected >= 0 && rsDecode(damaged, nparity) != 0 {
+		t.Fatalf("miscorrected to a non-codeword")
+	}
+}
+
+func TestRS(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		nparity := mathrand.Intn(32) + 2
+		n := nparity + mathrand.Intn(255-nparity) + 1
+		testRS(t, n, nparity, mathrand.Intn(nparity/2+1))
+	}
+	// Too many errors.
+	for i := 0; i < 100; i++ {
+		testRS(t, 255, 8, 5+mathrand.Intn(10))
+	}
+}
@@ -0,0 +1,103 @@
+// chris 101826 In-place embedding.
+
+package steg
+
+import (
+	"io"
+)
+
+// NewInPlaceWriter returns a fresh Writer like NewWriter, which embeds
+// into f itself, rather than copying a carrier into a destination.
+// Each chunk is read from f, and then only the byte holding the one bit
+// to flip is written back.  Chunks are streamed, as for a Writer, so
+// any atom size will do.
+//
+// Copy and CopyN seek past carrier bytes rather than copying them.
+func (ctx *Ctx) NewInPlaceWriter(f io.ReadWriteSeeker) *Writer {
+	w := ctx.NewWriter(nil, f)
+	w.inPlace = f
+	return w
+}
+
+// NewInPlaceMux returns a fresh Mux like NewMux, but with a Writer from
+// NewInPlaceWriter.
+func (ctx *Ctx) NewInPlaceMux(f io.ReadWriteSeeker, msg io.Reader) *Mux {
+	w := ctx.NewInPlaceWriter(f)
+	return &Mux{ctx: ctx, w: w, msg: msg}
+}
+
+// writeInPlace embeds the atom in the next chunk of w.inPlace, leaving
+// it positioned after the chunk.
+func (w *Writer) writeInPlace(a *atom) error {
+	f := w.inPlace
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	cur, err := w.ctx.readAtomStream(w.prog.cx, f, w.buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrShortCarrier
+	}
+	if err != nil {
+		return err
+	}
+	// Like chunk.write, leave the chunk alone if there's nothing
+	// to flip.
+	x := cur.asUint64() ^ a.asUint64()
+	if x == 0 {
+		w.noteFlips()
+		_, err = f.Seek(start+w.ctx.chunkSize, io.SeekStart)
+		return err
+	}
+	w.noteFlips(x)
+	Bi, mask := w.ctx.bitPos(x)
+	p := make([]byte, 1)
+	if w.ctx.streamed() {
+		_, err = f.Seek(start+Bi, io.SeekStart)
+		if err == nil {
+			_, err = io.ReadFull(f, p)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		// The whole chunk is still in the buffer.
+		p[0] = w.buf[Bi]
+	}
+	p[0] ^= mask
+	_, err = f.Seek(start+Bi, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(p)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(start+w.ctx.chunkSize, io.SeekStart)
+	return err
+}
+
+// skipInPlace seeks n bytes forward in w.inPlace, but no further than
+// its end, like io.CopyN.  n of -1 means to the end.
+func (w *Writer) skipInPlace(n int64) (skipped int64, err error) {
+	f := w.inPlace
+	cur, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if n == -1 || cur+n > end {
+		if n != -1 {
+			err = io.EOF
+		}
+		return end - cur, err
+	}
+	_, err = f.Seek(cur+n, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
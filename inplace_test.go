@@ -0,0 +1,93 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"io/ioutil"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testInPlace(t *testing.T, atomSize uint8, planes byte, natoms int) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	msg := make([]byte, natoms*int(atomSize)-mathrand.Intn(int(atomSize)))
+	offset := int64(mathrand.Intn(10))
+	carrier := make([]byte, offset+int64(natoms)*ctx.chunkSize+int64(mathrand.Intn(10)))
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := new(bytes.Buffer)
+	m := ctx.NewMux(expect, bytes.NewReader(carrier), bytes.NewReader(msg))
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "steg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(carrier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	m = ctx.NewInPlaceMux(f, bytes.NewReader(msg))
+	if n, err := m.CopyN(offset); n != offset || err != nil {
+		t.Fatalf("CopyN = %v, %v", n, err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	test, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, expect.Bytes()) {
+		t.Errorf("atom size %v, planes %#x: in-place mux differs", atomSize, planes)
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		testInPlace(t, 1, 0xff, mathrand.Intn(20)+1)
+		testInPlace(t, 2, 0x01, mathrand.Intn(3)+1)
+	}
+	// Streamed chunks.
+	testInPlace(t, 3, 0xff, 1)
+}
+
+func TestInPlaceShort(t *testing.T) {
+	ctx := NewCtx(1)
+	f, err := ioutil.TempFile("", "steg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(make([]byte, ctx.chunkSize-1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	w := ctx.NewInPlaceWriter(f)
+	n, err := w.Write([]byte{1})
+	if n != 0 || err != ErrShortCarrier {
+		t.Errorf("n = %v, err = %v (expected 0, ErrShortCarrier)", n, err)
+	}
+}
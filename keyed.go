@@ -0,0 +1,325 @@
+// chris 101826 Keyed pseudo-random atom placement.
+
+package steg
+
+import (
+	"io"
+	"sort"
+
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// keyStream is a deterministic pseudo-random source keyed by a secret.
+// It's AES-CTR keystream under a key derived from the secret and a
+// label, so that independent streams can be derived from one secret.
+type keyStream struct {
+	s   cipher.Stream
+	buf []byte
+	off int
+}
+
+func newKeyStream(secret []byte, label string) *keyStream {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	// A SHA-256 sum is a valid AES-256 key, so this can't fail.
+	block, _ := aes.NewCipher(mac.Sum(nil))
+	iv := make([]byte, aes.BlockSize)
+	buf := make([]byte, 4096)
+	return &keyStream{s: cipher.NewCTR(block, iv), buf: buf, off: len(buf)}
+}
+
+func (ks *keyStream) uint64() uint64 {
+	if ks.off == len(ks.buf) {
+		for i := range ks.buf {
+			ks.buf[i] = 0
+		}
+		ks.s.XORKeyStream(ks.buf, ks.buf)
+		ks.off = 0
+	}
+	x := binary.LittleEndian.Uint64(ks.buf[ks.off:])
+	ks.off += 8
+	return x
+}
+
+// intn returns a uniformly-distributed value in [0, n).
+func (ks *keyStream) intn(n uint64) uint64 {
+	// Reject values from the final, partial run of n so as not to
+	// bias toward low values.
+	limit := ^uint64(0) - ^uint64(0)%n
+	for {
+		x := ks.uint64()
+		if x < limit {
+			return x % n
+		}
+	}
+}
+
+// perm returns a pseudo-random permutation of [0, n).
+func (ks *keyStream) perm(n int) []uint32 {
+	p := make([]uint32, n)
+	for i := range p {
+		p[i] = uint32(i)
+	}
+	// Fisher-Yates.
+	for i := n - 1; i > 0; i-- {
+		j := ks.intn(uint64(i + 1))
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
+// A Schedule is a keyed, pseudo-random placement of atoms across a
+// carrier of known size.  Instead of consecutive chunks from the start
+// of the carrier, atom i is embedded in the chunk given by a
+// permutation of all of the carrier's chunk indexes, so the modified
+// chunks are spread across the whole carrier.  Optionally, the bytes
+// within each chunk are permuted as well, so that the position of the
+// flipped bit is unpredictable.
+//
+// The muxing and the reading side must use the same key, carrier size,
+// and byte permutation option to agree on a schedule.
+type Schedule struct {
+	ctx *Ctx
+	// Chunk index of each atom.
+	chunks []uint32
+	// Nil, or the chunk byte index at each permuted position.
+	bytes []uint32
+}
+
+// A KeyedMux multiplexes a message on a carrier into a destination
+// according to a Schedule.
+type KeyedMux struct {
+	ctx *Ctx
+	s   *Schedule
+
+	dst     io.Writer
+	carrier io.Reader
+	msg     io.Reader
+}
+
+// A KeyedReader reads steganographically-embedded bytes from a source
+// according to a Schedule.  Implements io.Reader.
+type KeyedReader struct {
+	ctx *Ctx
+	s   *Schedule
+	src io.Reader
+
+	// All of the atoms of the source, in message order, once read.
+	atoms []byte
+	err   error
+}
+
+// NewSchedule returns the schedule for the given key and carrier size,
+// in bytes.  If shuffleBytes is true, the bytes within each chunk are
+// permuted as well.
+//
+// The carrier size is the size of the carrier from which the schedule
+// will be read, i.e., after any offset.  Only chunks of up to the first
+// 4Gi chunks are used.  Panics if the atom size is above 3, since each
+// chunk is held in memory.
+func (ctx *Ctx) NewSchedule(key []byte, carrierSize int64, shuffleBytes bool) *Schedule {
+	if ctx.hugeChunks() {
+		panic("unsupported atom size")
+	}
+	n := carrierSize / int64(ctx.chunkSize)
+	if n > 1<<32-1 {
+		n = 1<<32 - 1
+	}
+	s := &Schedule{ctx: ctx}
+	s.chunks = newKeyStream(key, "chunks").perm(int(n))
+	if shuffleBytes {
+		s.bytes = newKeyStream(key, "bytes").perm(int(ctx.chunkSize))
+	}
+	return s
+}
+
+// Capacity returns the largest message the schedule can embed, in
+// bytes.
+func (s *Schedule) Capacity() int64 {
+	return int64(len(s.chunks)) * int64(s.ctx.atomSize)
+}
+
+// size returns the number of carrier bytes covered by the schedule.
+func (s *Schedule) size() int64 {
+	return int64(len(s.chunks)) * int64(s.ctx.chunkSize)
+}
+
+// shuffle returns a new chunk with the bytes of c in permuted order.
+func (s *Schedule) shuffle(c *chunk) *chunk {
+	if s.bytes == nil {
+		return c
+	}
+	sc := s.ctx.newChunk()
+	for i, cBi := range s.bytes {
+		sc.data[i] = c.data[cBi]
+	}
+	return sc
+}
+
+// write writes the atom into the chunk, permuting its bytes as needed.
+func (s *Schedule) write(c *chunk, a *atom) {
+	sc := s.shuffle(c)
+	sc.write(a)
+	if s.bytes == nil {
+		return
+	}
+	for i, cBi := range s.bytes {
+		c.data[cBi] = sc.data[i]
+	}
+}
+
+// readAtom reads an atom out of the chunk, permuting its bytes as
+// needed.
+func (s *Schedule) readAtom(c *chunk) *atom {
+	return s.shuffle(c).readAtom()
+}
+
+// NewKeyedMux returns a fresh KeyedMux, ready to multiplex a message on
+// a carrier into a destination according to the schedule.
+func (ctx *Ctx) NewKeyedMux(dst io.Writer, carrier, msg io.Reader, s *Schedule) *KeyedMux {
+	if s.ctx != ctx {
+		panic("schedule from a different context")
+	}
+	return &KeyedMux{ctx: ctx, s: s, dst: dst, carrier: carrier, msg: msg}
+}
+
+// NewKeyedReader returns a fresh KeyedReader, ready to read
+// steganographically-embedded bytes from the source io.Reader according
+// to the schedule.
+func (ctx *Ctx) NewKeyedReader(src io.Reader, s *Schedule) *KeyedReader {
+	if s.ctx != ctx {
+		panic("schedule from a different context")
+	}
+	return &KeyedReader{ctx: ctx, s: s, src: src}
+}
+
+// Mux reads the entire message into memory, steganographically embeds
+// its atoms into the chunks of the carrier given by the schedule, and
+// writes the resultant data into the destination writer.  The rest of
+// the carrier data is simply copied to the writer.
+//
+// As with Mux.Mux, a final partial atom is padded with zero bytes.
+// Returns ErrShortCarrier without writing anything if the message is
+// larger than the capacity of the schedule.  Can also return
+// ErrShortCarrier, having written part of the carrier, if an EOF was
+// encountered before being able to read the chunks of the schedule from
+// the carrier.
+//
+// Successful iff err == nil.
+func (m *KeyedMux) Mux() error {
+	msg, err := ioutil.ReadAll(m.msg)
+	if err != nil {
+		return err
+	}
+	atomSize := int(m.ctx.atomSize)
+	if rem := len(msg) % atomSize; rem != 0 {
+		msg = append(msg, make([]byte, atomSize-rem)...)
+	}
+	if int64(len(msg)) > m.s.Capacity() {
+		return ErrShortCarrier
+	}
+
+	// Visit the atoms in carrier order.
+	order := make([]int, len(msg)/atomSize)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return m.s.chunks[order[i]] < m.s.chunks[order[j]]
+	})
+
+	c := m.ctx.newChunk()
+	a := m.ctx.newAtom()
+	next := int64(0) // next chunk index to be read from the carrier
+	for _, i := range order {
+		ci := int64(m.s.chunks[i])
+		_, err = io.CopyN(m.dst, m.carrier, (ci-next)*int64(m.ctx.chunkSize))
+		if err != nil {
+			break
+		}
+		_, err = io.ReadFull(m.carrier, c.data)
+		if err != nil {
+			break
+		}
+		a.copy(msg[i*atomSize : (i+1)*atomSize])
+		m.s.write(c, a)
+		_, err = m.dst.Write(c.data)
+		if err != nil {
+			return err
+		}
+		next = ci + 1
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrShortCarrier
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(m.dst, m.carrier)
+	return err
+}
+
+// CopyN copies n bytes from the carrier to the destination.  The
+// schedule's carrier size should not include them.
+//
+// Counterpart to KeyedReader.Discard.
+func (m *KeyedMux) CopyN(n int64) (written int64, err error) {
+	return io.CopyN(m.dst, m.carrier, n)
+}
+
+// load reads every chunk of the schedule from the source, and reorders
+// their atoms into message order.
+func (r *KeyedReader) load() error {
+	atomSize := int(r.ctx.atomSize)
+	at := make([]uint32, len(r.s.chunks))
+	for i, ci := range r.s.chunks {
+		at[ci] = uint32(i)
+	}
+	atoms := make([]byte, len(at)*atomSize)
+	c := r.ctx.newChunk()
+	for _, i := range at {
+		_, err := io.ReadFull(r.src, c.data)
+		if err != nil {
+			return err
+		}
+		copy(atoms[int(i)*atomSize:], r.s.readAtom(c).data)
+	}
+	r.atoms = atoms
+	return nil
+}
+
+// Read reads steganographically-embedded bytes from the source
+// according to the schedule.  The first call reads all of the chunks
+// of the schedule from the source, keeping their atoms in memory.
+// Returns io.EOF once all of them have been read.
+//
+// Can return io.EOF or io.ErrUnexpectedEOF on the first call if the
+// source is smaller than the carrier size of the schedule.
+func (r *KeyedReader) Read(p []byte) (n int, err error) {
+	if r.atoms == nil && r.err == nil {
+		r.err = r.load()
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(r.atoms) == 0 {
+		return 0, io.EOF
+	}
+	n = copy(p, r.atoms)
+	r.atoms = r.atoms[n:]
+	return n, nil
+}
+
+// Discard reads n bytes from the source into ioutil.Discard, throwing
+// them away.  Call it before the first Read.
+//
+// Counterpart to KeyedMux.CopyN.
+func (r *KeyedReader) Discard(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, r.src, n)
+	return err
+}
@@ -0,0 +1,126 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func TestKeyStreamPerm(t *testing.T) {
+	p := newKeyStream([]byte("key"), "test").perm(1000)
+	seen := make([]bool, len(p))
+	for _, x := range p {
+		if seen[x] {
+			t.Fatalf("%v repeated in permutation", x)
+		}
+		seen[x] = true
+	}
+	q := newKeyStream([]byte("key"), "test").perm(1000)
+	if !equalUint32s(p, q) {
+		t.Error("same key and label yielded different permutations")
+	}
+	q = newKeyStream([]byte("key"), "other").perm(1000)
+	if equalUint32s(p, q) {
+		t.Error("different labels yielded the same permutation")
+	}
+}
+
+func equalUint32s(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testKeyedMux(t *testing.T, atomSize uint8, shuffleBytes bool) {
+	ctx := NewCtx(atomSize)
+	key := []byte("sesame")
+	const offset = 7
+
+	nchunks := 64
+	carrierBytes := make([]byte, offset+nchunks*int(ctx.chunkSize)+11)
+	if _, err := cryptorand.Read(carrierBytes); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, mathrand.Intn(nchunks*int(atomSize)/2)+1)
+	if _, err := cryptorand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	s := ctx.NewSchedule(key, int64(len(carrierBytes)-offset), shuffleBytes)
+	if s.Capacity() != int64(nchunks*int(atomSize)) {
+		t.Fatalf("capacity %v (expected %v)", s.Capacity(), nchunks*int(atomSize))
+	}
+	dst := new(bytes.Buffer)
+	m := ctx.NewKeyedMux(dst, bytes.NewReader(carrierBytes), bytes.NewReader(msg), s)
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != len(carrierBytes) {
+		t.Fatalf("wrote %v bytes (expected %v)", dst.Len(), len(carrierBytes))
+	}
+	testBytesDiff(t, carrierBytes, dst.Bytes(), (len(msg)+int(atomSize)-1)/int(atomSize))
+	if !bytes.Equal(carrierBytes[:offset], dst.Bytes()[:offset]) {
+		t.Error("offset bytes modified")
+	}
+
+	// Read back with the same schedule, recomputed.
+	s = ctx.NewSchedule(key, int64(len(carrierBytes)-offset), shuffleBytes)
+	r := ctx.NewKeyedReader(bytes.NewReader(dst.Bytes()), s)
+	if err := r.Discard(offset); err != nil {
+		t.Fatal(err)
+	}
+	test := make([]byte, len(msg))
+	if _, err := r.Read(test); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to read back %#v (got %#v)", msg, test)
+	}
+
+	// A different key should yield something else.
+	s = ctx.NewSchedule([]byte("open"), int64(len(carrierBytes)-offset), shuffleBytes)
+	r = ctx.NewKeyedReader(bytes.NewReader(dst.Bytes()[offset:]), s)
+	if _, err := r.Read(test); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg) > 4 && bytes.Equal(test, msg) {
+		t.Errorf("read back %#v with the wrong key", msg)
+	}
+}
+
+func TestKeyedMux(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		testKeyedMux(t, 1, false)
+		testKeyedMux(t, 1, true)
+	}
+	testKeyedMux(t, 2, false)
+	testKeyedMux(t, 2, true)
+}
+
+func TestKeyedMuxShort(t *testing.T) {
+	ctx := NewCtx(1)
+	carrierBytes := make([]byte, 10*ctx.chunkSize)
+	s := ctx.NewSchedule([]byte("key"), int64(len(carrierBytes)), false)
+	dst := new(bytes.Buffer)
+	msg := bytes.NewReader(make([]byte, 11))
+	err := ctx.NewKeyedMux(dst, bytes.NewReader(carrierBytes), msg, s).Mux()
+	if err != ErrShortCarrier {
+		t.Errorf("err = %v (expected %v)", err, ErrShortCarrier)
+	}
+	if dst.Len() != 0 {
+		t.Errorf("wrote %v bytes", dst.Len())
+	}
+}
@@ -0,0 +1,299 @@
+// chris 101826 Matrix embedding of bit-granular messages.
+
+package steg
+
+import (
+	"errors"
+	"io"
+
+	"io/ioutil"
+)
+
+// ErrNoFit is returned by TuneMatrix when no block size can embed the
+// message in the carrier within the flip budget.
+var ErrNoFit = errors.New("message doesn't fit carrier")
+
+// A MatrixCtx is a context for matrix embedding, an alternative to the
+// atoms and chunks of Ctx.  The message is treated as a stream of bits,
+// least-significant bit of each byte first, and embedded k bits at a
+// time in blocks of 2^k - 1 carrier bits, flipping at most one of them.
+// The carrier bits are numbered from 1 within each block, and the k
+// message bits are the xor of the numbers of the set ones, as for
+// atoms, but blocks needn't align with carrier bytes, so k may be any
+// of 2 through 24.  This trades capacity for fewer flips per message
+// bit at a much finer grain than the atom size does.
+//
+// Create muxes and readers from a MatrixCtx as from a Ctx.
+type MatrixCtx struct {
+	k uint8
+	// Mask and bit sub-indexes of the selected carrier bit planes;
+	// see NewPlaneCtx.
+	planes    byte
+	planeBits []uint8
+}
+
+// MatrixMux multiplexes a message on a carrier into a destination with
+// matrix embedding, like Mux.
+type MatrixMux struct {
+	ctx *MatrixCtx
+	cb  *carrierBits
+
+	dst     io.Writer
+	carrier io.Reader
+	msg     io.Reader
+}
+
+// A MatrixReader reads matrix-embedded bytes from a source io.Reader.
+// Implements io.Reader.
+type MatrixReader struct {
+	ctx *MatrixCtx
+	cb  *carrierBits
+
+	src io.Reader
+	// Extracted message bits not yet returned, least-significant
+	// first.
+	acc  uint64
+	nacc uint
+}
+
+// carrierBits is a window onto the carrier of a MatrixMux or
+// MatrixReader, holding the bytes of the current block.
+type carrierBits struct {
+	ctx *MatrixCtx
+	// Carrier bytes read, but not yet done with.
+	data []byte
+	// Number of the leading carrier bits of data already embedded
+	// in or extracted from.
+	pos uint64
+}
+
+// NewMatrixCtx returns a fresh MatrixCtx embedding k bits per block,
+// using the carrier bit planes selected by planes, like NewPlaneCtx.
+// Panics if k is not between 2 and 24, or if planes is zero.
+func NewMatrixCtx(k uint8, planes byte) *MatrixCtx {
+	if k < 2 || k > 24 {
+		panic("inappropriate block size")
+	}
+	if planes == 0 {
+		panic("no bit planes")
+	}
+	var planeBits []uint8
+	for bsi := uint8(0); bsi < 8; bsi++ {
+		if planes&(1<<bsi) != 0 {
+			planeBits = append(planeBits, bsi)
+		}
+	}
+	return &MatrixCtx{k: k, planes: planes, planeBits: planeBits}
+}
+
+// K returns the number of message bits per block.
+func (ctx *MatrixCtx) K() uint8 {
+	return ctx.k
+}
+
+// blockBits returns the number of carrier bits in a block.
+func (ctx *MatrixCtx) blockBits() uint64 {
+	return 1<<ctx.k - 1
+}
+
+// blocks returns the number of whole blocks in a carrier of the given
+// size, in bytes.
+func (ctx *MatrixCtx) blocks(carrierSize int64) int64 {
+	return carrierSize * int64(len(ctx.planeBits)) / int64(ctx.blockBits())
+}
+
+// Capacity returns the largest message a carrier of the given size can
+// embed, in bytes.
+func (ctx *MatrixCtx) Capacity(carrierSize int64) (messageSize int64) {
+	return ctx.blocks(carrierSize) * int64(ctx.k) / 8
+}
+
+// Flips returns the most carrier bits that embedding a message of the
+// given size can flip: one per block.  On average, a block goes
+// unflipped once in 2^k times.
+func (ctx *MatrixCtx) Flips(messageSize int64) int64 {
+	return (messageSize*8 + int64(ctx.k) - 1) / int64(ctx.k)
+}
+
+// TuneMatrix returns the MatrixCtx that embeds a message of the given
+// size in a carrier of the given size, with the given bit planes, while
+// flipping the fewest carrier bits.  That's the one with the biggest
+// blocks that still fit.  Returns ErrNoFit if even that would flip more
+// than maxFlips bits, or if the message doesn't fit at all.  If
+// maxFlips is -1, there's no limit.
+func TuneMatrix(carrierSize, messageSize int64, planes byte, maxFlips int64) (*MatrixCtx, error) {
+	for k := uint8(24); k >= 2; k-- {
+		ctx := NewMatrixCtx(k, planes)
+		if ctx.Capacity(carrierSize) < messageSize {
+			continue
+		}
+		if maxFlips != -1 && ctx.Flips(messageSize) > maxFlips {
+			// Smaller blocks would only flip more.
+			break
+		}
+		return ctx, nil
+	}
+	return nil, ErrNoFit
+}
+
+// fill reads carrier bytes until the current block is in memory.
+func (cb *carrierBits) fill(r io.Reader) error {
+	np := uint64(len(cb.ctx.planeBits))
+	need := (cb.pos + cb.ctx.blockBits() + np - 1) / np
+	if have := uint64(len(cb.data)); have < need {
+		p := make([]byte, need-have)
+		_, err := io.ReadFull(r, p)
+		if err != nil {
+			return err
+		}
+		cb.data = append(cb.data, p...)
+	}
+	return nil
+}
+
+// syndrome returns the xor of the numbers of the set bits of the
+// current block.
+func (cb *carrierBits) syndrome() uint64 {
+	np := uint64(len(cb.ctx.planeBits))
+	Bi, pi := cb.pos/np, cb.pos%np
+	s := uint64(0)
+	for i := uint64(1); i <= cb.ctx.blockBits(); i++ {
+		if cb.data[Bi]&(1<<cb.ctx.planeBits[pi]) != 0 {
+			s ^= i
+		}
+		pi++
+		if pi == np {
+			pi = 0
+			Bi++
+		}
+	}
+	return s
+}
+
+// flip flips the carrier bit numbered i in the current block.
+func (cb *carrierBits) flip(i uint64) {
+	np := uint64(len(cb.ctx.planeBits))
+	g := cb.pos + i - 1
+	cb.data[g/np] ^= 1 << cb.ctx.planeBits[g%np]
+}
+
+// next moves on from the current block, and returns the carrier bytes
+// that are done with.
+func (cb *carrierBits) next() []byte {
+	cb.pos += cb.ctx.blockBits()
+	np := uint64(len(cb.ctx.planeBits))
+	n := cb.pos / np
+	done := cb.data[:n:n]
+	cb.data = cb.data[n:]
+	cb.pos -= n * np
+	return done
+}
+
+// NewMux returns a fresh MatrixMux, ready to multiplex a message on a
+// carrier into a destination.
+func (ctx *MatrixCtx) NewMux(dst io.Writer, carrier, msg io.Reader) *MatrixMux {
+	cb := &carrierBits{ctx: ctx}
+	return &MatrixMux{ctx: ctx, cb: cb, dst: dst, carrier: carrier, msg: msg}
+}
+
+// NewReader returns a fresh MatrixReader, ready to read matrix-embedded
+// bytes from the source io.Reader.
+func (ctx *MatrixCtx) NewReader(src io.Reader) *MatrixReader {
+	cb := &carrierBits{ctx: ctx}
+	return &MatrixReader{ctx: ctx, cb: cb, src: src}
+}
+
+// Mux embeds the message k bits at a time, like Mux.Mux, and then
+// copies the rest of the carrier through.  If the message isn't a
+// multiple of k bits, the final block is padded with zero bits.
+//
+// Can return ErrShortCarrier if the carrier ends before the message.
+func (m *MatrixMux) Mux() error {
+	k := uint(m.ctx.k)
+	var acc uint64
+	var nacc uint
+	p := make([]byte, 1)
+	eof := false
+	for {
+		for nacc < k && !eof {
+			_, err := io.ReadFull(m.msg, p)
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			acc |= uint64(p[0]) << nacc
+			nacc += 8
+		}
+		if nacc == 0 {
+			break
+		}
+		x := acc & (1<<k - 1)
+		acc >>= k
+		if nacc < k {
+			nacc = 0
+		} else {
+			nacc -= k
+		}
+
+		err := m.cb.fill(m.carrier)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrShortCarrier
+		}
+		if err != nil {
+			return err
+		}
+		if i := m.cb.syndrome() ^ x; i != 0 {
+			m.cb.flip(i)
+		}
+		_, err = m.dst.Write(m.cb.next())
+		if err != nil {
+			return err
+		}
+	}
+	_, err := m.dst.Write(m.cb.data)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(m.dst, m.carrier)
+	return err
+}
+
+// CopyN copies n bytes from the carrier to the destination, like
+// Mux.CopyN.  Call it before Mux.
+func (m *MatrixMux) CopyN(n int64) (written int64, err error) {
+	return io.CopyN(m.dst, m.carrier, n)
+}
+
+// Read reads matrix-embedded bytes, like Reader.Read.
+//
+// Can return io.EOF or io.ErrUnexpectedEOF if the source ends before
+// enough blocks could be read.
+func (r *MatrixReader) Read(p []byte) (n int, err error) {
+	k := uint(r.ctx.k)
+	for n < len(p) {
+		for r.nacc < 8 {
+			err = r.cb.fill(r.src)
+			if err != nil {
+				return n, err
+			}
+			r.acc |= r.cb.syndrome() << r.nacc
+			r.nacc += k
+			r.cb.next()
+		}
+		p[n] = byte(r.acc)
+		r.acc >>= 8
+		r.nacc -= 8
+		n++
+	}
+	return n, nil
+}
+
+// Discard reads n bytes from the source, throwing them away, like
+// Reader.Discard.  Call it before Read.
+func (r *MatrixReader) Discard(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, r.src, n)
+	return err
+}
@@ -0,0 +1,100 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"testing"
+
+	"math/bits"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testMatrix(t *testing.T, k uint8, planes byte) {
+	ctx := NewMatrixCtx(k, planes)
+	msg := make([]byte, mathrand.Intn(50)+1)
+	offset := int64(mathrand.Intn(10))
+	carrierSize := offset + int64(len(msg)*8/int(k)+2)*int64(ctx.blockBits())/int64(bits.OnesCount8(planes)) + 3
+	carrier := make([]byte, carrierSize)
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ctx.Capacity(carrierSize-offset) < int64(len(msg)) {
+		t.Fatalf("capacity %v < %v", ctx.Capacity(carrierSize-offset), len(msg))
+	}
+
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrier), bytes.NewReader(msg))
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != len(carrier) {
+		t.Fatalf("muxed %v bytes (expected %v)", dst.Len(), len(carrier))
+	}
+	flips := 0
+	for i, B := range dst.Bytes() {
+		if (B^carrier[i])&^planes != 0 {
+			t.Errorf("flipped bit outside of planes %#x", planes)
+		}
+		flips += bits.OnesCount8(B ^ carrier[i])
+	}
+	if int64(flips) > ctx.Flips(int64(len(msg))) {
+		t.Errorf("%v flips (expected at most %v), k = %v", flips, ctx.Flips(int64(len(msg))), k)
+	}
+
+	r := ctx.NewReader(bytes.NewReader(dst.Bytes()))
+	if err := r.Discard(offset); err != nil {
+		t.Fatal(err)
+	}
+	test := make([]byte, len(msg))
+	if _, err := r.Read(test); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to read back, k = %v, planes = %#x", k, planes)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		testMatrix(t, 2, 0xff)
+		testMatrix(t, 3, 0x01)
+		testMatrix(t, 7, 0x05)
+		testMatrix(t, 8, 0xff)
+		testMatrix(t, 13, 0x03)
+	}
+	testMatrix(t, 20, 0xff)
+}
+
+func TestMatrixShortCarrier(t *testing.T) {
+	ctx := NewMatrixCtx(4, 0xff)
+	m := ctx.NewMux(new(bytes.Buffer), bytes.NewReader(make([]byte, 3)), bytes.NewReader([]byte("hi")))
+	if err := m.Mux(); err != ErrShortCarrier {
+		t.Errorf("mux error %v (expected %v)", err, ErrShortCarrier)
+	}
+}
+
+func TestTuneMatrix(t *testing.T) {
+	// 8000 carrier bits; 800 message bits.
+	ctx, err := TuneMatrix(1000, 100, 0xff, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6 bits per 63 carrier bits only fits 6*126 = 756 bits.
+	if ctx.K() != 5 {
+		t.Errorf("tuned k = %v (expected 5)", ctx.K())
+	}
+	if _, err := TuneMatrix(1000, 100, 0xff, 100); err != ErrNoFit {
+		t.Errorf("tune error %v (expected %v)", err, ErrNoFit)
+	}
+	if _, err := TuneMatrix(1000, 1000, 0xff, -1); err != ErrNoFit {
+		t.Errorf("tune error %v (expected %v)", err, ErrNoFit)
+	}
+}
@@ -2,13 +2,12 @@
 
 package steg
 
-import "io"
-
-// Mux reads, one atom at a time, from the message reader,
+// Mux reads from the message reader, a buffer at a time,
 // steganographically embeds its data into the data read from the
 // carrier, and then writes the resultant data into the destination
-// writer.  If the carrier has more than enough data for the message,
-// the rest of the carrier data is simply copied to the writer.
+// writer; see Writer.ReadFrom.  If the carrier has more than enough
+// data for the message, the rest of the carrier data is simply copied
+// to the writer.
 //
 // Can return ErrShortCarrier if an EOF was encountered before being
 // able to read a sufficient number amount of data from the carrier for
@@ -21,38 +20,10 @@ import "io"
 //
 // Successful iff err != nil.
 func (m *Mux) Mux() (err error) {
-	// Would be nice if we could just call io.Copy, but the buffer
-	// size isn't ensured to be a multiple of the atom size.
-
-	// XXX Just reading one atom at a time for simplicity.  The
-	// caller can certainly wrap its readers and writers in buffered
-	// versions, but this is still internally a lot of function
-	// calls for a reasonable amount of data...
-
-	var n int
-	a := m.ctx.newAtom()
-	for {
-		n, err = io.ReadFull(m.msg, a.data)
-		if err != nil {
-			if err == io.EOF {
-				// We're all done reading from the
-				// message reader.
-				break
-			}
-			if err != io.ErrUnexpectedEOF {
-				return err
-			}
-			// We only read some of the bytes, but that's
-			// ok.  We zero out the remaining, as they might
-			// have been used for scratch, or contain data
-			// from the previous read.  The next read will
-			// EOF, and we'll be done.
-			a.zero(n)
-		}
-		_, err = m.w.Write(a.data)
-		if err != nil {
-			return err
-		}
+	// The Writer takes care of atom alignment and padding.
+	_, err = m.w.ReadFrom(m.msg)
+	if err != nil {
+		return err
 	}
 	_, err = m.w.Copy()
 	return err
@@ -0,0 +1,95 @@
+// chris 101826 Writes of any length.
+
+package steg
+
+import (
+	"errors"
+)
+
+// ErrClosed is returned by PadWriter.Write after Close.
+var ErrClosed = errors.New("write to closed writer")
+
+// A PadWriter wraps a Writer, accepting writes of any length.  Message
+// bytes short of a whole atom are held back until the next Write, and
+// on Close, the final partial atom is padded with zero bytes and
+// embedded, as by Mux.  Implements io.WriteCloser.
+type PadWriter struct {
+	w *Writer
+	// Whether Close copies the rest of the carrier through.
+	copyRest bool
+
+	// Residual message bytes, short of an atom.
+	res  []byte
+	nres int
+
+	closed bool
+}
+
+// NewPadWriter returns a fresh PadWriter wrapping w.  If copyRest is
+// set, Close also copies the rest of the carrier through to the
+// destination, as by Writer.Copy, as Mux does.
+func NewPadWriter(w *Writer, copyRest bool) *PadWriter {
+	return &PadWriter{w: w, copyRest: copyRest, res: w.ctx.newAtom().data}
+}
+
+// Write embeds bytes, like Writer.Write, but p may be of any length.
+// Whole atoms are embedded immediately, and the rest held back.
+//
+// Returns the number of bytes of p either embedded or held back, and
+// the Writer's error, if any.  An error embedding held-back bytes is
+// returned by the Write or Close that embeds them.
+func (pw *PadWriter) Write(p []byte) (n int, err error) {
+	if pw.closed {
+		return 0, ErrClosed
+	}
+	as := len(pw.res)
+	if pw.nres > 0 {
+		nn := copy(pw.res[pw.nres:], p)
+		pw.nres += nn
+		if pw.nres < as {
+			return nn, nil
+		}
+		_, err = pw.w.Write(pw.res)
+		if err != nil {
+			pw.nres -= nn
+			return 0, err
+		}
+		pw.nres = 0
+		n += nn
+	}
+	whole := n + (len(p)-n)/as*as
+	nn, err := pw.w.Write(p[n:whole])
+	n += nn
+	if err != nil {
+		return n, err
+	}
+	pw.nres = copy(pw.res, p[whole:])
+	n += pw.nres
+	return n, nil
+}
+
+// Close pads and embeds the final partial atom, if any, and then
+// copies the rest of the carrier through, if the PadWriter was created
+// to.  Further Writes return ErrClosed.  Doesn't close the underlying
+// destination.
+func (pw *PadWriter) Close() error {
+	if pw.closed {
+		return ErrClosed
+	}
+	pw.closed = true
+	if pw.nres > 0 {
+		for i := pw.nres; i < len(pw.res); i++ {
+			pw.res[i] = 0
+		}
+		_, err := pw.w.Write(pw.res)
+		if err != nil {
+			return err
+		}
+		pw.nres = 0
+	}
+	if pw.copyRest {
+		_, err := pw.w.Copy()
+		return err
+	}
+	return nil
+}
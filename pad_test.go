@@ -0,0 +1,67 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testPadWriter(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	msg := make([]byte, mathrand.Intn(50))
+	carrier := make([]byte, (len(msg)/int(atomSize)+1)*int(ctx.chunkSize)+mathrand.Intn(10))
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := new(bytes.Buffer)
+	if err := ctx.NewMux(expect, bytes.NewReader(carrier), bytes.NewReader(msg)).Mux(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := new(bytes.Buffer)
+	pw := NewPadWriter(ctx.NewWriter(dst, bytes.NewReader(carrier)), true)
+	for p := msg; len(p) > 0; {
+		k := mathrand.Intn(len(p)) + 1
+		n, err := pw.Write(p[:k])
+		if n != k || err != nil {
+			t.Fatalf("Write(%v bytes) = %v, %v", k, n, err)
+		}
+		p = p[k:]
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), expect.Bytes()) {
+		t.Errorf("atom size %v: PadWriter differs from Mux", atomSize)
+	}
+	if _, err := pw.Write([]byte{0}); err != ErrClosed {
+		t.Errorf("Write after Close err = %v (expected ErrClosed)", err)
+	}
+}
+
+func TestPadWriter(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		testPadWriter(t, 1, 0xff)
+		testPadWriter(t, 2, 0xff)
+		testPadWriter(t, 2, 0x01)
+	}
+}
+
+func TestPadWriterShortCarrier(t *testing.T) {
+	ctx := NewCtx(2)
+	dst := new(bytes.Buffer)
+	pw := NewPadWriter(ctx.NewWriter(dst, bytes.NewReader(make([]byte, ctx.chunkSize))), false)
+	if n, err := pw.Write([]byte{1, 2, 3}); n != 3 || err != nil {
+		t.Fatalf("Write = %v, %v", n, err)
+	}
+	if err := pw.Close(); err != ErrShortCarrier {
+		t.Errorf("Close err = %v (expected ErrShortCarrier)", err)
+	}
+}
@@ -0,0 +1,366 @@
+// chris 101826 Concurrent embedding and extraction.
+
+package steg
+
+import (
+	"io"
+	"sync"
+
+	"io/ioutil"
+)
+
+// batchSize is the least number of carrier bytes handed to a worker at
+// once, so that small chunks don't drown in synchronization overhead.
+const batchSize = 64 * 1024
+
+// A ParallelWriter is like a Writer, but embeds into chunks on multiple
+// goroutines.  Implements io.Writer.
+type ParallelWriter struct {
+	ctx     *Ctx
+	workers int
+
+	dst     io.Writer
+	carrier io.Reader
+
+	// How far we've got; see Watch.
+	prog progress
+}
+
+// A ParallelReader is like a Reader, but extracts from chunks on
+// multiple goroutines.  Implements io.Reader.
+type ParallelReader struct {
+	ctx     *Ctx
+	workers int
+
+	src io.Reader
+
+	// Current atom whose bytes we're returning when Read calls are
+	// made, and the number of its bytes remaining.
+	cur *atom
+	cn  int
+
+	// How far we've got; see Watch.
+	prog progress
+}
+
+// ParallelMux is like Mux, but with a ParallelWriter.
+type ParallelMux struct {
+	ctx *Ctx
+
+	w   *ParallelWriter
+	msg io.Reader
+}
+
+// A batch is a run of consecutive chunks and their atoms.
+type batch struct {
+	chunks []byte
+	atoms  []byte
+	// Number of chunks and atoms in use.
+	n    int
+	done chan struct{}
+}
+
+// batchAtoms returns the number of atoms in a batch.
+func (ctx *Ctx) batchAtoms() int {
+	n := batchSize / int(ctx.chunkSize)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (ctx *Ctx) newBatch() *batch {
+	n := ctx.batchAtoms()
+	return &batch{
+		chunks: make([]byte, n*int(ctx.chunkSize)),
+		atoms:  make([]byte, n*int(ctx.atomSize)),
+		done:   make(chan struct{}, 1),
+	}
+}
+
+// chunk returns the ith chunk of the batch.
+func (b *batch) chunk(ctx *Ctx, i int) *chunk {
+	cs := int(ctx.chunkSize)
+	return &chunk{ctx: ctx, data: b.chunks[i*cs : (i+1)*cs]}
+}
+
+// atom returns the ith atom of the batch.
+func (b *batch) atom(ctx *Ctx, i int) *atom {
+	as := int(ctx.atomSize)
+	return &atom{ctx: ctx, data: b.atoms[i*as : (i+1)*as]}
+}
+
+// readChunks reads as many of the batch's chunks from r as it can,
+// setting b.n to the number read in full.
+func (b *batch) readChunks(ctx *Ctx, r io.Reader) error {
+	cs := int(ctx.chunkSize)
+	n, err := io.ReadFull(r, b.chunks[:b.n*cs])
+	if err == io.ErrUnexpectedEOF && n%cs == 0 {
+		// Like reading a chunk at a time, which would have hit
+		// EOF on a chunk boundary.
+		err = io.EOF
+	}
+	b.n = n / cs
+	return err
+}
+
+// pipeline passes natoms atoms' worth of chunks through the workers in
+// batches.  fill is called with each batch on a single goroutine, with
+// b.n set to the number of atoms wanted; it sets b.n to the number it
+// got, and returns an error to stop early.  process is called with
+// each filled batch on one of the workers.  flush is called with each
+// processed batch, in order, on the calling goroutine; an error from it
+// stops the pipeline.  Returns the first error from flush, if any, and
+// otherwise the error from fill.
+//
+// At most two batches per worker are in flight at once.
+func (ctx *Ctx) pipeline(workers, natoms int, fill func(b *batch) error, process func(b *batch), flush func(b *batch) error) error {
+	if natoms == 0 {
+		return nil
+	}
+	free := make(chan *batch, 2*workers)
+	for i := 0; i < cap(free); i++ {
+		free <- ctx.newBatch()
+	}
+	work := make(chan *batch, cap(free))
+	order := make(chan *batch, cap(free))
+	quit := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for b := range work {
+				process(b)
+				b.done <- struct{}{}
+			}
+		}()
+	}
+
+	var fillErr error
+	go func() {
+		defer close(order)
+		defer close(work)
+		for natoms > 0 {
+			var b *batch
+			select {
+			case b = <-free:
+			case <-quit:
+				return
+			}
+			// Both may have been ready, and select picks either,
+			// so check again before reading more of the carrier.
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			b.n = ctx.batchAtoms()
+			if b.n > natoms {
+				b.n = natoms
+			}
+			fillErr = fill(b)
+			natoms -= b.n
+			if b.n > 0 {
+				work <- b
+				order <- b
+			}
+			if fillErr != nil {
+				return
+			}
+		}
+	}()
+
+	var err error
+	for b := range order {
+		<-b.done
+		if err == nil {
+			err = flush(b)
+			if err != nil {
+				close(quit)
+			}
+		}
+		free <- b
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	// The filling goroutine has exited, since order is closed.
+	return fillErr
+}
+
+// NewParallelWriter returns a fresh ParallelWriter like NewWriter, which
+// embeds on the given number of worker goroutines.  Panics if workers
+// is less than 1, or if the atom size is above 3, since each worker
+// holds chunks in memory.
+func (ctx *Ctx) NewParallelWriter(dst io.Writer, carrier io.Reader, workers int) *ParallelWriter {
+	if workers < 1 {
+		panic("inappropriate worker count")
+	}
+	if ctx.hugeChunks() {
+		panic("unsupported atom size")
+	}
+	return &ParallelWriter{ctx: ctx, workers: workers, dst: dst, carrier: carrier}
+}
+
+// NewParallelReader returns a fresh ParallelReader like NewReader, which
+// extracts on the given number of worker goroutines.  Panics like
+// NewParallelWriter.
+func (ctx *Ctx) NewParallelReader(src io.Reader, workers int) *ParallelReader {
+	if workers < 1 {
+		panic("inappropriate worker count")
+	}
+	if ctx.hugeChunks() {
+		panic("unsupported atom size")
+	}
+	return &ParallelReader{ctx: ctx, workers: workers, src: src}
+}
+
+// NewParallelMux returns a fresh ParallelMux like NewMux, which embeds
+// on the given number of worker goroutines.  Panics like
+// NewParallelWriter.
+func (ctx *Ctx) NewParallelMux(dst io.Writer, carrier, msg io.Reader, workers int) *ParallelMux {
+	w := ctx.NewParallelWriter(dst, carrier, workers)
+	return &ParallelMux{ctx: ctx, w: w, msg: msg}
+}
+
+// Write is like Writer.Write.  The carrier is read and the destination
+// written in order, with the same error semantics.  In particular, n
+// counts only atoms whose chunks have been written in full.
+func (w *ParallelWriter) Write(p []byte) (n int, err error) {
+	as := int(w.ctx.atomSize)
+	cs := int(w.ctx.chunkSize)
+	if len(p)%as != 0 {
+		return 0, ErrInsufficientData
+	}
+	// Atoms handed to the pipeline so far.
+	na := 0
+	fill := func(b *batch) error {
+		copy(b.atoms, p[na*as:(na+b.n)*as])
+		err := b.readChunks(w.ctx, w.carrier)
+		na += b.n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrShortCarrier
+		}
+		return err
+	}
+	process := func(b *batch) {
+		for i := 0; i < b.n; i++ {
+			b.chunk(w.ctx, i).write(b.atom(w.ctx, i))
+		}
+	}
+	flush := func(b *batch) error {
+		nn, err := w.dst.Write(b.chunks[:b.n*cs])
+		// A partially-written chunk is considered not to have
+		// been written.
+		n += nn / cs * as
+		if err != nil {
+			return err
+		}
+		return w.prog.add(w.ctx, int64(b.n), int64(nn))
+	}
+	err = w.ctx.pipeline(w.workers, len(p)/as, fill, process, flush)
+	return n, err
+}
+
+// Copy is like Writer.Copy.
+func (w *ParallelWriter) Copy() (written int64, err error) {
+	if w.prog.watched() {
+		return copyWatched(w.CopyN)
+	}
+	written, err = io.Copy(w.dst, w.carrier)
+	w.prog.add(w.ctx, 0, written)
+	return written, err
+}
+
+// CopyN is like Writer.CopyN.
+func (w *ParallelWriter) CopyN(n int64) (written int64, err error) {
+	written, err = io.CopyN(w.dst, w.carrier, n)
+	if perr := w.prog.add(w.ctx, 0, written); err == nil {
+		err = perr
+	}
+	return written, err
+}
+
+// Read is like Reader.Read.  The source is read in order, and no
+// further than it takes to fill p.
+func (r *ParallelReader) Read(p []byte) (n int, err error) {
+	as := int(r.ctx.atomSize)
+	if r.cur != nil {
+		n = copy(p, r.cur.data[as-r.cn:])
+		r.cn -= n
+		if r.cn == 0 {
+			r.cur = nil
+		}
+	}
+	fill := func(b *batch) error {
+		return b.readChunks(r.ctx, r.src)
+	}
+	process := func(b *batch) {
+		for i := 0; i < b.n; i++ {
+			b.atom(r.ctx, i).copy(b.chunk(r.ctx, i).readAtom().data)
+		}
+	}
+	flush := func(b *batch) error {
+		nn := copy(p[n:], b.atoms[:b.n*as])
+		n += nn
+		if nn < b.n*as {
+			// Keep the rest of the final atom for the next
+			// Read.
+			r.cur = r.ctx.newAtom()
+			r.cur.copy(b.atoms[(b.n-1)*as : b.n*as])
+			r.cn = b.n*as - nn
+		}
+		return r.prog.add(r.ctx, int64(b.n), int64(b.n)*r.ctx.chunkSize)
+	}
+	natoms := (len(p) - n + as - 1) / as
+	err = r.ctx.pipeline(r.workers, natoms, fill, process, flush)
+	return n, err
+}
+
+// Discard is like Reader.Discard.
+func (r *ParallelReader) Discard(n int64) error {
+	nn, err := io.CopyN(ioutil.Discard, r.src, n)
+	if perr := r.prog.add(r.ctx, 0, nn); err == nil {
+		err = perr
+	}
+	return err
+}
+
+// Mux is like Mux.Mux, but reads the message a number of batches at a
+// time to keep the workers busy.
+func (m *ParallelMux) Mux() (err error) {
+	as := int(m.ctx.atomSize)
+	p := make([]byte, 4*m.w.workers*m.ctx.batchAtoms()*as)
+	for {
+		var n int
+		n, err = io.ReadFull(m.msg, p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// Pad the final partial atom with zero bytes.
+		end := (n + as - 1) / as * as
+		for i := n; i < end; i++ {
+			p[i] = 0
+		}
+		_, err = m.w.Write(p[:end])
+		if err != nil {
+			return err
+		}
+		if end < len(p) {
+			break
+		}
+	}
+	_, err = m.w.Copy()
+	return err
+}
+
+// CopyN is like Mux.CopyN.
+func (m *ParallelMux) CopyN(n int64) (written int64, err error) {
+	return m.w.CopyN(n)
+}
@@ -0,0 +1,127 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testParallel(t *testing.T, atomSize uint8, workers int) {
+	ctx := NewCtx(atomSize)
+	msg := make([]byte, mathrand.Intn(20000)+1)
+	carrier := make([]byte, int64(len(msg)+int(atomSize))*int64(ctx.chunkSize)/int64(atomSize)+19)
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := new(bytes.Buffer)
+	if err := ctx.NewMux(expect, bytes.NewReader(carrier), bytes.NewReader(msg)).Mux(); err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	if err := ctx.NewParallelMux(dst, bytes.NewReader(carrier), bytes.NewReader(msg), workers).Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), expect.Bytes()) {
+		t.Fatalf("parallel mux differs, atomSize = %v, workers = %v", atomSize, workers)
+	}
+
+	// Read back in reads of assorted sizes.
+	r := ctx.NewParallelReader(bytes.NewReader(dst.Bytes()), workers)
+	test := make([]byte, len(msg))
+	for n := 0; n < len(test); {
+		end := n + mathrand.Intn(5000) + 1
+		if end > len(test) {
+			end = len(test)
+		}
+		nn, err := r.Read(test[n:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += nn
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to read back, atomSize = %v, workers = %v", atomSize, workers)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		testParallel(t, 1, 1)
+		testParallel(t, 1, 4)
+		testParallel(t, 2, 3)
+	}
+}
+
+func TestParallelShortCarrier(t *testing.T) {
+	ctx := NewCtx(1)
+	msg := make([]byte, 10000)
+	carrier := make([]byte, 5000*int(ctx.chunkSize)+7)
+	w := ctx.NewParallelWriter(new(bytes.Buffer), bytes.NewReader(carrier), 4)
+	n, err := w.Write(msg)
+	if err != ErrShortCarrier || n != 5000 {
+		t.Errorf("wrote %v, %v (expected 5000, %v)", n, err, ErrShortCarrier)
+	}
+
+	r := ctx.NewParallelReader(bytes.NewReader(carrier), 4)
+	n, err = r.Read(msg)
+	if err != io.ErrUnexpectedEOF || n != 5000 {
+		t.Errorf("read %v, %v (expected 5000, %v)", n, err, io.ErrUnexpectedEOF)
+	}
+
+	// Ending on a chunk boundary.
+	r = ctx.NewParallelReader(bytes.NewReader(carrier[:5000*ctx.chunkSize]), 4)
+	n, err = r.Read(msg)
+	if err != io.EOF || n != 5000 {
+		t.Errorf("read %v, %v (expected 5000, %v)", n, err, io.EOF)
+	}
+}
+
+var errTestWrite = errors.New("test write error")
+
+// limitWriter writes up to n bytes, and then fails.
+type limitWriter struct {
+	n int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errTestWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestParallelWriteError(t *testing.T) {
+	ctx := NewCtx(1)
+	msg := make([]byte, 100000)
+	carrier := make([]byte, len(msg)*int(ctx.chunkSize))
+	// Once the write fails, in the second batch, nothing more is
+	// filled, so only the batches already in flight have been read:
+	// the first, and then the two per worker.
+	ba := ctx.batchAtoms()
+	most := (3000/ba + 2*4) * ba * int(ctx.chunkSize)
+	// Which of the ready cases select picks is random, so try a few
+	// times.
+	for i := 0; i < 20; i++ {
+		r := bytes.NewReader(carrier)
+		dst := &limitWriter{n: 3000*int(ctx.chunkSize) + 5}
+		n, err := ctx.NewParallelWriter(dst, r, 4).Write(msg)
+		if err != errTestWrite || n != 3000 {
+			t.Fatalf("wrote %v, %v (expected 3000, %v)", n, err, errTestWrite)
+		}
+		if read := len(carrier) - r.Len(); read > most {
+			t.Fatalf("read %v carrier bytes (expected at most %v)", read, most)
+		}
+	}
+}
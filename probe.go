@@ -0,0 +1,84 @@
+// chris 101826 Detection of embedding parameters.
+
+package steg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+)
+
+// ErrNoBox is returned by Probe and Ctx.Probe when no box header is
+// found.
+var ErrNoBox = errors.New("no box found")
+
+// A ProbeResult records the parameters with which a box was found.
+type ProbeResult struct {
+	AtomSize uint8
+	// Carrier offset of the box.
+	Offset int64
+	Header *Header
+}
+
+// probeAt looks for a box header at the carrier offset off.  Returns
+// io.EOF if the source is too short to hold the first atom there.
+func (ctx *Ctx) probeAt(src io.ReaderAt, off int64) (*Header, error) {
+	r := ctx.NewReader(io.NewSectionReader(src, off, math.MaxInt64-off))
+	// Reject most offsets on the first atom alone, so that the rest
+	// of the header's chunks need only be read for likely ones.
+	p := make([]byte, HeaderSize)
+	_, err := r.Read(p[:ctx.atomSize])
+	if err != nil {
+		return nil, io.EOF
+	}
+	if !bytes.Equal(p[:ctx.atomSize], boxMagic[:ctx.atomSize]) {
+		return nil, ErrNoBox
+	}
+	_, err = io.ReadFull(r, p[ctx.atomSize:])
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	h, err := ParseHeader(p)
+	if err != nil {
+		return nil, err
+	}
+	if h.AtomSize != ctx.atomSize {
+		return nil, ErrBadHeader
+	}
+	return h, nil
+}
+
+// Probe looks for a box header embedded in src with the context's atom
+// size and bit planes, at each carrier offset from 0 through maxOffset
+// in turn.  Returns the first one found, or ErrNoBox.  Only boxed data
+// can be found; see Header.
+//
+// Each offset probed costs a chunk read, so probing with atom size 3
+// is slow.
+func (ctx *Ctx) Probe(src io.ReaderAt, maxOffset int64) (*ProbeResult, error) {
+	for off := int64(0); off <= maxOffset; off++ {
+		h, err := ctx.probeAt(src, off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		return &ProbeResult{AtomSize: ctx.atomSize, Offset: off, Header: h}, nil
+	}
+	return nil, ErrNoBox
+}
+
+// Probe is like Ctx.Probe, but tries atom sizes 1, 2, and 3 in turn,
+// with the given bit planes.  Use it to recover the parameters with
+// which a box was muxed when they're not known.
+func Probe(src io.ReaderAt, planes byte, maxOffset int64) (*ProbeResult, error) {
+	for atomSize := uint8(1); atomSize <= 3; atomSize++ {
+		p, err := NewPlaneCtx(atomSize, planes).Probe(src, maxOffset)
+		if err == nil {
+			return p, nil
+		}
+	}
+	return nil, ErrNoBox
+}
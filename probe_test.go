@@ -0,0 +1,64 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testProbe(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	payload := []byte(helloString)
+	h := &Header{Version: BoxVersion, AtomSize: atomSize, Length: int64(len(payload))}
+	boxed := h.BoxedSize()
+	offset := int64(mathrand.Intn(100))
+	carrier := make([]byte, offset+(boxed/int64(atomSize)+2)*int64(ctx.chunkSize))
+	if _, err := cryptorand.Read(carrier); err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrier), NewBoxer(bytes.NewReader(payload), h))
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Probe(bytes.NewReader(dst.Bytes()), planes, 100)
+	if err != nil {
+		t.Fatalf("probe error %v, atom size = %v, offset = %v", err, atomSize, offset)
+	}
+	if p.AtomSize != atomSize || p.Offset != offset || *p.Header != *h {
+		t.Errorf("probed %+v, %+v (expected atom size %v, offset %v)", *p, *p.Header, atomSize, offset)
+	}
+
+	// Not within range.
+	if offset > 0 {
+		if _, err := ctx.Probe(bytes.NewReader(dst.Bytes()), offset-1); err != ErrNoBox {
+			t.Errorf("probe short of offset: %v (expected %v)", err, ErrNoBox)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		testProbe(t, 1, 0xff)
+		testProbe(t, 1, 0x01)
+		testProbe(t, 2, 0xff)
+	}
+}
+
+func TestProbeNoBox(t *testing.T) {
+	carrier := make([]byte, 64*1024)
+	if _, err := cryptorand.Read(carrier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Probe(bytes.NewReader(carrier), 0xff, 100); err != ErrNoBox {
+		t.Errorf("probe error %v (expected %v)", err, ErrNoBox)
+	}
+}
@@ -0,0 +1,168 @@
+// chris 101826 Cancellation and progress reporting.
+
+package steg
+
+import (
+	"context"
+	"io"
+)
+
+// progressInterval is the number of carrier bytes between progress
+// reports and cancellation checks.
+const progressInterval = 1024 * 1024
+
+// Progress reports how far embedding or extraction has got.
+type Progress struct {
+	// Atoms embedded or extracted so far.
+	Atoms int64
+	// Carrier bytes consumed so far, including any copied through or
+	// discarded, out of CarrierSize, which is -1 if unknown.
+	CarrierBytes int64
+	CarrierSize  int64
+	// Message bytes the rest of the carrier can still hold, or -1 if
+	// the carrier size isn't known.
+	Remaining int64
+}
+
+// A ProgressFunc is called with progress reports.  It's called on the
+// goroutine doing the embedding or extraction, so it should be quick.
+type ProgressFunc func(p Progress)
+
+// progress tracks how far a Writer or Reader has got, reporting it to
+// a ProgressFunc and checking a context.Context for cancellation every
+// progressInterval carrier bytes, once watched.
+type progress struct {
+	p Progress
+
+	cx context.Context
+	f  ProgressFunc
+	// Carrier size, or -1 if unknown.
+	size int64
+	// Carrier bytes consumed as of the last report.
+	last int64
+}
+
+// watch starts reporting progress to f, if non-nil, and checking cx for
+// cancellation, if non-nil.
+func (pr *progress) watch(cx context.Context, size int64, f ProgressFunc) {
+	pr.cx, pr.f, pr.size = cx, f, size
+}
+
+func (pr *progress) watched() bool {
+	return pr.cx != nil || pr.f != nil
+}
+
+// add counts atoms and carrier bytes, and reports and checks for
+// cancellation if it's been long enough.  Returns the context's error
+// if it's done.
+func (pr *progress) add(ctx *Ctx, atoms, carrierBytes int64) error {
+	pr.p.Atoms += atoms
+	pr.p.CarrierBytes += carrierBytes
+	if pr.p.CarrierBytes-pr.last < progressInterval {
+		return nil
+	}
+	return pr.report(ctx)
+}
+
+// report reports progress, and returns the context's error if it's
+// done.
+func (pr *progress) report(ctx *Ctx) error {
+	pr.last = pr.p.CarrierBytes
+	if pr.f != nil {
+		p := pr.p
+		p.CarrierSize = pr.size
+		p.Remaining = -1
+		if pr.size != -1 {
+			p.Remaining = ctx.Capacity(pr.size - p.CarrierBytes)
+			if p.Remaining < 0 {
+				p.Remaining = 0
+			}
+		}
+		pr.f(p)
+	}
+	if pr.cx != nil {
+		return pr.cx.Err()
+	}
+	return nil
+}
+
+// canceled returns cx.Err() if cx is non-nil.  Streamed chunks check
+// it between windows, so that cancellation needn't wait for a whole
+// chunk, which above atom size 3 can be very large.
+func canceled(cx context.Context) error {
+	if cx == nil {
+		return nil
+	}
+	return cx.Err()
+}
+
+// copyWatched copies the rest of a carrier with copyN a
+// progressInterval at a time, so that progress is reported and
+// cancellation checked along the way.
+func copyWatched(copyN func(n int64) (int64, error)) (written int64, err error) {
+	for {
+		n, err := copyN(progressInterval)
+		written += n
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// Watch makes subsequent Writes, Copies, and CopyNs report progress to
+// f, if it's non-nil, and stop with cx.Err() once cx is done, if it's
+// non-nil.  They do so every megabyte or so of carrier, and the
+// remaining capacity reported is based on carrierSize, which is -1 if
+// unknown.
+func (w *Writer) Watch(cx context.Context, carrierSize int64, f ProgressFunc) {
+	w.prog.watch(cx, carrierSize, f)
+}
+
+// Watch makes subsequent Reads and Discards report progress and stop
+// once cx is done, like Writer.Watch, with srcSize the size of the
+// source.
+func (r *Reader) Watch(cx context.Context, srcSize int64, f ProgressFunc) {
+	r.prog.watch(cx, srcSize, f)
+}
+
+// Watch is like Writer.Watch.
+func (w *ParallelWriter) Watch(cx context.Context, carrierSize int64, f ProgressFunc) {
+	w.prog.watch(cx, carrierSize, f)
+}
+
+// Watch is like Reader.Watch.
+func (r *ParallelReader) Watch(cx context.Context, srcSize int64, f ProgressFunc) {
+	r.prog.watch(cx, srcSize, f)
+}
+
+// MuxContext is like Mux, but stops with cx.Err() once cx is done, and
+// reports progress to f along the way, if it's non-nil; see
+// Writer.Watch.  Progress is also reported once done.  Carrier bytes
+// copied by any earlier CopyN are counted.
+func (m *Mux) MuxContext(cx context.Context, carrierSize int64, f ProgressFunc) error {
+	m.w.Watch(cx, carrierSize, f)
+	err := cx.Err()
+	if err == nil {
+		err = m.Mux()
+	}
+	if rerr := m.w.prog.report(m.ctx); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// MuxContext is like Mux.MuxContext.
+func (m *ParallelMux) MuxContext(cx context.Context, carrierSize int64, f ProgressFunc) error {
+	m.w.Watch(cx, carrierSize, f)
+	err := cx.Err()
+	if err == nil {
+		err = m.Mux()
+	}
+	if rerr := m.w.prog.report(m.ctx); err == nil {
+		err = rerr
+	}
+	return err
+}
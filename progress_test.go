@@ -0,0 +1,139 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"io/ioutil"
+
+	cryptorand "crypto/rand"
+)
+
+// testProgressMuxer is implemented by Mux and ParallelMux.
+type testProgressMuxer interface {
+	CopyN(n int64) (int64, error)
+	MuxContext(cx context.Context, carrierSize int64, f ProgressFunc) error
+}
+
+func testProgressMux(t *testing.T, m testProgressMuxer, natoms int, carrierSize int64) {
+	var reports []Progress
+	if _, err := m.CopyN(17); err != nil {
+		t.Fatal(err)
+	}
+	err := m.MuxContext(context.Background(), carrierSize, func(p Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) < 2 {
+		t.Fatalf("%v progress reports (expected several)", len(reports))
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].CarrierBytes < reports[i-1].CarrierBytes || reports[i].Remaining > reports[i-1].Remaining {
+			t.Errorf("progress went backwards: %+v, then %+v", reports[i-1], reports[i])
+		}
+	}
+	last := reports[len(reports)-1]
+	expect := Progress{Atoms: int64(natoms), CarrierBytes: carrierSize, CarrierSize: carrierSize}
+	if last != expect {
+		t.Errorf("final progress %+v (expected %+v)", last, expect)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	ctx := NewCtx(1)
+	natoms := 3 * progressInterval / int(ctx.chunkSize)
+	carrier := make([]byte, 17+int64(natoms)*ctx.chunkSize+5)
+	msg := make([]byte, natoms)
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := ctx.NewMux(ioutil.Discard, bytes.NewReader(carrier), bytes.NewReader(msg))
+	testProgressMux(t, m, natoms, int64(len(carrier)))
+	pm := ctx.NewParallelMux(ioutil.Discard, bytes.NewReader(carrier), bytes.NewReader(msg), 4)
+	testProgressMux(t, pm, natoms, int64(len(carrier)))
+}
+
+func TestProgressCancel(t *testing.T) {
+	ctx := NewCtx(1)
+	carrier := make([]byte, 4*progressInterval)
+	msg := make([]byte, 4*progressInterval/ctx.chunkSize)
+
+	cx, cancel := context.WithCancel(context.Background())
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrier), bytes.NewReader(msg))
+	err := m.MuxContext(cx, -1, func(p Progress) {
+		if p.Remaining != -1 {
+			t.Errorf("remaining %v for unknown carrier size", p.Remaining)
+		}
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Errorf("MuxContext err = %v (expected context.Canceled)", err)
+	}
+	if dst.Len() >= len(carrier) {
+		t.Errorf("muxed all %v bytes despite cancellation", dst.Len())
+	}
+
+	// Already cancelled.
+	if err := ctx.NewMux(dst, bytes.NewReader(carrier), bytes.NewReader(msg)).MuxContext(cx, -1, nil); err != context.Canceled {
+		t.Errorf("MuxContext err = %v (expected context.Canceled)", err)
+	}
+
+	for _, r := range []interface {
+		io.Reader
+		Watch(cx context.Context, srcSize int64, f ProgressFunc)
+	}{
+		ctx.NewReader(bytes.NewReader(carrier)),
+		ctx.NewParallelReader(bytes.NewReader(carrier), 4),
+	} {
+		r.Watch(cx, int64(len(carrier)), nil)
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != context.Canceled {
+			t.Errorf("%T: io.Copy err = %v (expected context.Canceled)", r, err)
+		}
+		if n >= int64(len(msg)) {
+			t.Errorf("%T: read all %v bytes despite cancellation", r, n)
+		}
+	}
+}
+
+// testCancelReader reads zeros, calling cancel once it's read after
+// bytes.
+type testCancelReader struct {
+	n, after int64
+	cancel   func()
+}
+
+func (r *testCancelReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	r.n += int64(len(p))
+	if r.n >= r.after {
+		r.cancel()
+	}
+	return len(p), nil
+}
+
+func TestProgressCancelStreamed(t *testing.T) {
+	// 512MiB chunks.
+	ctx := NewCtx(4)
+	cx, cancel := context.WithCancel(context.Background())
+	src := &testCancelReader{after: progressInterval, cancel: cancel}
+	r := ctx.NewReader(src)
+	r.Watch(cx, -1, nil)
+	if _, err := r.Read(make([]byte, 4)); err != context.Canceled {
+		t.Errorf("Read err = %v (expected context.Canceled)", err)
+	}
+	if src.n > 2*progressInterval {
+		t.Errorf("read %v carrier bytes after cancellation at %v", src.n, src.after)
+	}
+}
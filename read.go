@@ -3,90 +3,182 @@
 package steg
 
 import (
+	"context"
 	"errors"
 	"io"
-	"io/ioutil"
 
-	"chrispennello.com/go/swar"
+	"encoding/binary"
+	"io/ioutil"
+	"math/bits"
 )
 
 // ErrShortRead will be returned from Read and Reader.Read when an EOF
 // is encountered before being able to read sufficient data.
 var ErrShortRead = errors.New("short read")
 
-func (a *atom) asUint32() uint32 {
-	r := uint32(0)
+// window is the number of carrier bytes of a streamed chunk handled at
+// a time.  It's a multiple of 64, so that the packed bits of each
+// window but the last fill whole words, whatever the bit planes.
+const window = 64 * 1024
+
+// msgBufSize is the size of the message buffer used by Writer.ReadFrom
+// and Reader.WriteTo, give or take atom alignment.
+const msgBufSize = 32 * 1024
+
+func (a *atom) asUint64() uint64 {
+	r := uint64(0)
 	for i := uint8(0); i < uint8(a.ctx.atomSize); i++ {
-		r |= uint32(a.data[i]) << (i * 8)
+		r |= uint64(a.data[i]) << (i * 8)
 	}
 	return r
 }
 
-// readBitMask xors the bits specified out of the byte B by applying the
-// mask and then stores the value in the atom a at the specified atom
-// bit index abi.
-func (c *chunk) readBitMask(a *atom, abi uint8, mask byte, B byte) {
-	// First, extract the desired bits from the chunk byte by using
-	// the mask.
-	x := mask & B
-	// We then want to XOR together the bits specified by the mask.
-	// The key is to recognize that this is the same as taking the
-	// 8-bit population count (ones count, or Hamming weight) and
-	// then examining the parity.  If even, then 0; if odd, then 1.
-	x = swar.Ones8(x) % 2
-	// XOR the bit into the atom.
-	a.xorBit(x, abi)
-	// Done!
+// byteParity holds, for each bit sub-index of a byte, the mask of the
+// bits whose sub-index has that bit set.  The parity of a byte under
+// mask i is bit i of the xor of the sub-indexes of its set bits.
+var byteParity = [3]byte{0xaa, 0xcc, 0xf0}
+
+// wordParity is like byteParity, but for the byte index within a
+// little-endian 64-bit word.  The parity of a word under mask i is bit
+// i of the xor of the byte indexes of its odd-parity bytes.
+var wordParity = [3]uint64{
+	0xff00ff00ff00ff00,
+	0xffff0000ffff0000,
+	0xffffffff00000000,
+}
+
+// parity returns the parity of the set bits of x, 0 or 1.
+func parity(x uint64) uint64 {
+	return uint64(bits.OnesCount64(x) & 1)
+}
+
+// pack returns the selected carrier bits of data packed contiguously,
+// the bits of the lowest selected plane first, and the number of them.
+// At most nbits bits are packed; any unused bits of the final byte
+// are left out.  The result is zero-padded to a whole number of words.
+// If all bit planes are selected, this is simply data itself.
+func (ctx *Ctx) pack(data []byte, nbits uint64) ([]byte, uint64) {
+	if ctx.planes == 0xff {
+		return data, uint64(len(data)) * 8
+	}
+	n := uint64(len(data)) * uint64(len(ctx.planeBits))
+	if n > nbits {
+		n = nbits
+	}
+	p := make([]byte, (n+63)/64*8)
+	// cbi: chunk bit index, relative to data
+	cbi := uint64(0)
+	for _, B := range data {
+		for _, bsi := range ctx.planeBits {
+			if cbi == n {
+				return p, n
+			}
+			xorBit(p, (B>>bsi)&1, cbi)
+			cbi++
+		}
+	}
+	return p, n
+}
+
+// bits returns the carrier bits of the chunk packed contiguously.  See
+// Ctx.pack.
+func (c *chunk) bits() []byte {
+	p, _ := c.ctx.pack(c.data, c.ctx.chunkBits())
+	return p
+}
+
+// A fold accumulates the atom of a chunk as its packed carrier bits
+// are passed through it, a word at a time.
+//
+// The atom is the xor of the chunk bit indexes of all of the set
+// carrier bits.  Rather than visit each bit, we split each bit index
+// into its word index, its byte index within the word, and its bit
+// sub-index within the byte, and account for each part separately.
+// The word index contributes iff the word has odd parity.  Since
+// parity is linear, the other two parts only depend on the xor of all
+// of the words, and we pick them out of it with the parity masks.
+// This way, the chunk is read a word at a time with one population
+// count for each.
+type fold struct {
+	// Xor of all of the words.
+	sum uint64
+	// Xor of the indexes of the odd-parity words.
+	x uint64
+	// wi: next word index
+	wi uint64
+}
+
+// add folds in the next packed carrier bits, whose length must be a
+// multiple of the word size.
+func (f *fold) add(p []byte) {
+	for ; len(p) > 0; p = p[8:] {
+		w := binary.LittleEndian.Uint64(p)
+		f.sum ^= w
+		f.x ^= f.wi & -parity(w)
+		f.wi++
+	}
 }
 
-// readBit xors the bits specified out of the byte B by computing a mask
-// and invoking readBitMask.
-func (c *chunk) readBit(a *atom, abi uint8, cBi uint32, B byte) {
-	// We compute the power of two threshold for this bit index.  If
-	// the byte index is above this value (mod power), then we will
-	// include these bytes in the xor for this bit.  If it's below,
-	// then we will not.  This is expressed as using a mask of
-	// either 0xff (for inclusion) or 0x00 (for exclusion).  This
-	// threshold exponentially increases with each atom bit index
-	// abi until ultimately we are excluding the entirety of the
-	// bottom half of the bytes and including the entirety of the
-	// top half.  See the table in the notes.
-
-	// c.ctx.atomSize won't be bigger than 3, so abi will be no
-	// larger than 23, so power or thresh won't overflow an int32.
-	thresh := int32(1) << (uint8(abi) - 3)
-	power := thresh << 1
-	// c.ctx.atomSize won't be bigger than 3, so cBi will be less
-	// than 2Mi., so cBi won't overflow an int32.
-	value := int32(cBi) % power
-	mask := byte(swar.IntegerSelect32(value, thresh, 0x00, 0xff))
-	c.readBitMask(a, abi, mask, B)
+// atom returns a new atom holding the value folded so far.
+func (f *fold) atom(ctx *Ctx) *atom {
+	x := f.x << 3
+	for i, mask := range wordParity {
+		x |= parity(f.sum&mask) << uint(i)
+	}
+	x <<= 3
+	// Fold the xor of all of the words down to a byte.
+	sum := f.sum
+	sum ^= sum >> 32
+	sum ^= sum >> 16
+	sum ^= sum >> 8
+	for i, mask := range byteParity {
+		x |= parity(sum&uint64(mask)) << uint(i)
+	}
+	a := ctx.newAtom()
+	for i := range a.data {
+		a.data[i] = byte(x >> (uint(i) * 8))
+	}
+	return a
 }
 
 // readAtom creates a new atom and reads its contents out of the chunk.
 func (c *chunk) readAtom() *atom {
-	a := c.ctx.newAtom()
-	atomBits := c.ctx.atomSize * 8
-	// cBi: chunk byte index
-	for cBi := uint32(0); cBi < c.ctx.chunkSize; cBi++ {
-		B := c.data[cBi]
-
-		// Bit indexes 0, 1, and 2 are special.  This is because
-		// for these index values, the bits to be selected from
-		// the chunk data are all within a byte.  Above these
-		// bit indexes, we simply select particular *bytes* _en
-		// masse_, but below them, we must select subsets of
-		// bits within the bytes.
-		c.readBitMask(a, 0, 0xaa, B)
-		c.readBitMask(a, 1, 0xcc, B)
-		c.readBitMask(a, 2, 0xf0, B)
-
-		// abi: atom bit index
-		for abi := uint8(3); abi < atomBits; abi++ {
-			c.readBit(a, abi, cBi, B)
+	var f fold
+	// Chunks hold at least 32 bytes of carrier bits, a multiple of
+	// the word size.
+	f.add(c.bits())
+	return f.atom(c.ctx)
+}
+
+// readAtomStream reads a chunk from r through buf, a window at a time,
+// and returns its atom, without holding the whole chunk in memory.
+// Like io.ReadFull, returns io.EOF if no bytes of the chunk could be
+// read, and io.ErrUnexpectedEOF if only some could.  Stops with
+// cx.Err() once cx, if non-nil, is done, checking between windows.
+func (ctx *Ctx) readAtomStream(cx context.Context, r io.Reader, buf []byte) (*atom, error) {
+	var f fold
+	nbits := ctx.chunkBits()
+	for rem := ctx.chunkSize; rem > 0; {
+		if err := canceled(cx); err != nil {
+			return nil, err
 		}
+		p := buf
+		if int64(len(p)) > rem {
+			p = p[:rem]
+		}
+		_, err := io.ReadFull(r, p)
+		if err == io.EOF && rem != ctx.chunkSize {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		q, n := ctx.pack(p, nbits)
+		f.add(q)
+		nbits -= n
+		rem -= int64(len(p))
 	}
-	return a
+	return f.atom(ctx), nil
 }
 
 // Read reads steganographically-embedded bytes from the underlying
@@ -99,22 +191,35 @@ func (c *chunk) readAtom() *atom {
 //
 // n == len(p) iff err != nil
 //
-// The current implementation is somewhat naive.  Each chunk is read
-// completely into memory from the underlying source reader.  In
-// particular, for atom size 3, this means that 2MiB at a time will be
-// read into memory.
+// Chunks of up to 64KiB are read whole; bigger ones are streamed
+// through a window of that size.  Either way, the buffer is reused
+// across reads, so memory use doesn't grow with the atom size.
 func (r *Reader) Read(p []byte) (n int, err error) {
-	c := r.ctx.newChunk()
+	if r.buf == nil {
+		r.buf = make([]byte, r.ctx.bufSize())
+	}
 	for n < len(p) {
 		if r.cur == nil {
-			_, err = io.ReadFull(r.src, c.data)
+			if r.ctx.streamed() {
+				r.cur, err = r.ctx.readAtomStream(r.prog.cx, r.src, r.buf)
+				if err != nil {
+					return n, err
+				}
+			} else {
+				c := &chunk{ctx: r.ctx, data: r.buf}
+				_, err = io.ReadFull(r.src, c.data)
+				if err != nil {
+					return n, err
+				}
+				r.cur = c.readAtom()
+			}
+			r.cn = int(r.ctx.atomSize)
+			err = r.prog.add(r.ctx, 1, r.ctx.chunkSize)
 			if err != nil {
 				return n, err
 			}
-			r.cur = c.readAtom()
-			r.cn = int(c.ctx.atomSize)
 		}
-		nn := copy(p[n:], r.cur.data[int(c.ctx.atomSize)-r.cn:])
+		nn := copy(p[n:], r.cur.data[int(r.ctx.atomSize)-r.cn:])
 		n += nn
 		r.cn -= nn
 		if r.cn == 0 {
@@ -124,6 +229,34 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// WriteTo writes steganographically-embedded bytes to w until the
+// source ends, and returns the number written.  Implements io.WriterTo,
+// so that io.Copy from a Reader reuses a single buffer.
+//
+// Like io.Copy, the source ending on a chunk boundary is not an error.
+// Otherwise, Read's errors are returned.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.msgBuf == nil {
+		r.msgBuf = r.ctx.newMsgBuf()
+	}
+	for {
+		nr, rerr := r.Read(r.msgBuf)
+		if nr > 0 {
+			nw, werr := w.Write(r.msgBuf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
 // Discard reads n bytes into ioutil.Discard, throwing them away.
 //
 // The idea is that you'd call this to jump ahead by some offset in the
@@ -132,6 +265,9 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 //
 // Counterpart to Writer.CopyN and Mux.CopyN.
 func (r *Reader) Discard(n int64) error {
-	_, err := io.CopyN(ioutil.Discard, r.src, n)
+	nn, err := io.CopyN(ioutil.Discard, r.src, n)
+	if perr := r.prog.add(r.ctx, 0, nn); err == nil {
+		err = perr
+	}
 	return err
 }
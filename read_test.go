@@ -44,11 +44,39 @@ func testReadAtomRepeat(t *testing.T, atomSize uint8) {
 	}
 }
 
+// testReadAtomNaive compares readAtom against the xor of the bit
+// indexes of the set carrier bits of a random chunk, computed one bit
+// at a time.
+func testReadAtomNaive(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	c := ctx.newChunk()
+	if _, err := cryptorand.Read(c.data); err != nil {
+		t.Fatal(err)
+	}
+	expect := uint64(0)
+	for cbi, B := range c.bits() {
+		for bsi := uint64(0); bsi < 8; bsi++ {
+			if B&(1<<bsi) != 0 {
+				expect ^= uint64(cbi)<<3 | bsi
+			}
+		}
+	}
+	if out := c.readAtom().asUint64(); out != expect {
+		t.Errorf("read atom %#x (expected %#x), atomSize = %v, planes = %#x", out, expect, atomSize, planes)
+	}
+}
+
 func TestReadAtom(t *testing.T) {
 	testReadAtomHello1(t)
 	testReadAtomHello2(t)
 	testReadAtomRepeat(t, 1)
 	testReadAtomRepeat(t, 2)
+	for i := 0; i < 10; i++ {
+		testReadAtomNaive(t, 1, 0xff)
+		testReadAtomNaive(t, 2, 0xff)
+		testReadAtomNaive(t, 2, 0x03)
+	}
+	testReadAtomNaive(t, 3, 0xff)
 }
 
 func testReaderHello(t *testing.T) {
@@ -145,10 +173,10 @@ func TestReader(t *testing.T) {
 }
 
 func testAsUint(t *testing.T, a *atom) {
-	p := make([]byte, 4)
+	p := make([]byte, 8)
 	copy(p, a.data)
-	out := a.asUint32()
-	expect := binary.LittleEndian.Uint32(p)
+	out := a.asUint64()
+	expect := binary.LittleEndian.Uint64(p)
 	if out != expect {
 		t.Errorf("(%v).asUint() != %v (was %v)", a.data, expect, out)
 	}
@@ -171,4 +199,6 @@ func TestAsUint(t *testing.T) {
 	testAsUintCtx(t, 1)
 	testAsUintCtx(t, 2)
 	testAsUintCtx(t, 3)
+	testAsUintCtx(t, 4)
+	testAsUintCtx(t, 7)
 }
@@ -4,8 +4,13 @@ package steg
 
 import (
 	"bytes"
+	"io"
+	"os"
 	"testing"
 
+	"path/filepath"
+	"testing/iotest"
+
 	cryptorand "crypto/rand"
 	mathrand "math/rand"
 )
@@ -130,3 +135,247 @@ func TestReaderWriter(t *testing.T) {
 		testReaderWriterRandom(t, 2)
 	}
 }
+
+func testReadWritePlanes(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	a := ctx.newAtom()
+	if _, err := cryptorand.Read(a.data); err != nil {
+		t.Error(err)
+		return
+	}
+	c := ctx.newChunk()
+	if _, err := cryptorand.Read(c.data); err != nil {
+		t.Error(err)
+		return
+	}
+	backup := ctx.newChunk()
+	copy(backup.data, c.data)
+	c.write(a)
+	testChunkDiff(t, backup, c)
+	for i := range c.data {
+		if (c.data[i]^backup.data[i])&^planes != 0 {
+			t.Errorf("flipped bit outside of planes %#x: %#x -> %#x",
+				planes, backup.data[i], c.data[i])
+		}
+	}
+	r := c.readAtom()
+	if !bytes.Equal(r.data, a.data) {
+		t.Errorf("didn't read back %v after writing to chunk (got %v), planes %#x",
+			a.data, r.data, planes)
+	}
+}
+
+func TestReadWritePlanes(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		testReadWritePlanes(t, 1, 0x01)
+		testReadWritePlanes(t, 1, 0x03)
+		testReadWritePlanes(t, 1, 0x07)
+		testReadWritePlanes(t, 1, 0xa4)
+	}
+	for i := 0; i < 10; i++ {
+		testReadWritePlanes(t, 2, 0x01)
+		testReadWritePlanes(t, 2, 0x06)
+	}
+}
+
+// testReadWriteStream streams chunks through a small window, as is done
+// for atom sizes above 3, and compares with the in-memory chunks.
+func testReadWriteStream(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	a := ctx.newAtom()
+	if _, err := cryptorand.Read(a.data); err != nil {
+		t.Fatal(err)
+	}
+	c := ctx.newChunk()
+	if _, err := cryptorand.Read(c.data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3*64)
+
+	r, err := ctx.readAtomStream(nil, bytes.NewReader(c.data), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := c.readAtom(); !bytes.Equal(r.data, expect.data) {
+		t.Errorf("streamed atom %v (expected %v), planes %#x", r.data, expect.data, planes)
+	}
+
+	dst := new(bytes.Buffer)
+	w := ctx.NewWriter(dst, bytes.NewReader(c.data))
+	w.buf = buf
+	if err := w.writeStream(a); err != nil {
+		t.Fatal(err)
+	}
+	backup := ctx.newChunk()
+	copy(backup.data, c.data)
+	c.write(a)
+	if !bytes.Equal(dst.Bytes(), c.data) {
+		t.Errorf("streamed write differs, planes %#x", planes)
+	}
+	testChunkDiff(t, backup, c)
+}
+
+// testMuxStream muxes with chunks streamed by each of the means
+// available, and compares with muxing in memory.
+func testMuxStream(t *testing.T, planes byte) {
+	ctx := NewPlaneCtx(3, planes)
+	if !ctx.streamed() {
+		panic("chunks not streamed")
+	}
+	msg := make([]byte, 6)
+	carrierBytes := make([]byte, 2*ctx.chunkSize+5)
+	for _, p := range [][]byte{msg, carrierBytes} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// In memory.
+	expect := new(bytes.Buffer)
+	w := ctx.NewWriter(expect, bytes.NewReader(carrierBytes))
+	w.c = ctx.newChunk()
+	if _, err := w.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Copy(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read twice.
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrierBytes), bytes.NewReader(msg))
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), expect.Bytes()) {
+		t.Errorf("two-pass mux differs, planes %#x", planes)
+	}
+
+	// Unseekable carrier falls back on memory.
+	dst.Reset()
+	m = ctx.NewMux(dst, bytes.NewBuffer(carrierBytes), bytes.NewReader(msg))
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), expect.Bytes()) {
+		t.Errorf("unseekable mux differs, planes %#x", planes)
+	}
+
+	// Patched files.
+	dir := t.TempDir()
+	carrier, err := os.Create(filepath.Join(dir, "carrier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer carrier.Close()
+	if _, err := carrier.Write(carrierBytes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := carrier.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	m = ctx.NewMux(out, carrier, bytes.NewReader(msg))
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.w.canPatch() {
+		t.Errorf("didn't patch files")
+	}
+	test, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, expect.Bytes()) {
+		t.Errorf("patched mux differs, planes %#x", planes)
+	}
+}
+
+func TestMuxStream(t *testing.T) {
+	testMuxStream(t, 0xff)
+	testMuxStream(t, 0x01)
+}
+
+func TestReadWriteStream(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		testReadWriteStream(t, 1, 0xff)
+		testReadWriteStream(t, 2, 0xff)
+		testReadWriteStream(t, 2, 0x07)
+	}
+	testReadWriteStream(t, 3, 0x01)
+
+	// The carrier is never read at atom size 4 if it can't seek.
+	ctx := NewCtx(4)
+	w := ctx.NewWriter(new(bytes.Buffer), bytes.NewBufferString("x"))
+	if n, err := w.Write(make([]byte, 4)); n != 0 || err != ErrUnseekable {
+		t.Errorf("wrote %v, %v (expected 0, %v)", n, err, ErrUnseekable)
+	}
+	w = ctx.NewWriter(new(bytes.Buffer), bytes.NewReader(make([]byte, 1000)))
+	if n, err := w.Write(make([]byte, 4)); n != 0 || err != ErrShortCarrier {
+		t.Errorf("wrote %v, %v (expected 0, %v)", n, err, ErrShortCarrier)
+	}
+	r := ctx.NewReader(bytes.NewReader(make([]byte, 1000)))
+	if n, err := r.Read(make([]byte, 4)); n != 0 || err != io.ErrUnexpectedEOF {
+		t.Errorf("read %v, %v (expected 0, %v)", n, err, io.ErrUnexpectedEOF)
+	}
+}
+
+func testReadFromWriteTo(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	secret := make([]byte, mathrand.Intn(3*msgBufSize/int(ctx.chunkSize)+1))
+	carrierBytes := make([]byte, (len(secret)/int(atomSize)+1)*int(ctx.chunkSize))
+	for _, p := range [][]byte{secret, carrierBytes} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Through io.Copy, in pieces that aren't atom-aligned.
+	dst := new(bytes.Buffer)
+	w := ctx.NewWriter(dst, bytes.NewReader(carrierBytes))
+	n, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(secret)))
+	if n != int64(len(secret)) || err != nil {
+		t.Fatalf("io.Copy to Writer = %v, %v (expected %v)", n, err, len(secret))
+	}
+	if _, err := w.Copy(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same as writing the padded message in one go.
+	padded := make([]byte, (len(secret)+int(atomSize)-1)/int(atomSize)*int(atomSize))
+	copy(padded, secret)
+	expect := new(bytes.Buffer)
+	w = ctx.NewWriter(expect, bytes.NewReader(carrierBytes))
+	if _, err := w.Write(padded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Copy(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), expect.Bytes()) {
+		t.Fatalf("atom size %v: ReadFrom differs from Write", atomSize)
+	}
+
+	// Read it all back, up to the whole carrier's worth.
+	test := new(bytes.Buffer)
+	r := ctx.NewReader(bytes.NewReader(dst.Bytes()))
+	n, err = io.Copy(test, r)
+	if n != int64(len(carrierBytes)/int(ctx.chunkSize)*int(atomSize)) || err != nil {
+		t.Fatalf("io.Copy from Reader = %v, %v", n, err)
+	}
+	if !bytes.Equal(test.Bytes()[:len(secret)], secret) {
+		t.Errorf("atom size %v: failed to read back", atomSize)
+	}
+}
+
+func TestReadFromWriteTo(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		testReadFromWriteTo(t, 1, 0xff)
+		testReadFromWriteTo(t, 2, 0xff)
+		testReadFromWriteTo(t, 2, 0x03)
+	}
+}
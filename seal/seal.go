@@ -0,0 +1,142 @@
+// chris 101826
+
+// Package seal implements an authenticated encryption layer for
+// messages to be steganographically embedded.
+//
+// Bytes embedded with package steg are plaintext to anyone who knows
+// the atom size and offset used.  Sealing a message before muxing it
+// makes it unreadable and tamper-evident without a password.
+//
+// A key is derived from the password with Argon2id, a memory-hard key
+// derivation function, using a random salt.  The message is then
+// encrypted and authenticated with AES-256-GCM using a random nonce.
+// The sealed message is laid out as follows.
+//
+//	version      1 byte
+//	salt        16 bytes
+//	nonce       12 bytes
+//	length       4 bytes, big-endian length of the ciphertext
+//	ciphertext  length bytes, including the 16-byte GCM tag
+//
+// The header, all but the ciphertext, is authenticated along with the
+// message.  Since the length is recorded, a sealed message can be
+// opened straight from a steg.Reader, without regard to whatever
+// carrier data follows it.
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrAuth is returned by Open when a sealed message can't be
+// authenticated.  This is the case for a wrong password, a message that
+// was tampered with or truncated, or data that was never sealed in the
+// first place.
+var ErrAuth = errors.New("message authentication failed")
+
+const (
+	version   = 1
+	saltSize  = 16
+	nonceSize = 12
+	tagSize   = 16
+	keySize   = 32
+
+	headerSize = 1 + saltSize + nonceSize + 4
+
+	// Overhead is the number of bytes by which sealing increases
+	// the size of a message.
+	Overhead = headerSize + tagSize
+)
+
+// Argon2id parameters.  Changing these requires a new version.
+const (
+	kdfTime    = 1
+	kdfMemory  = 64 * 1024 // in KiB
+	kdfThreads = 4
+)
+
+func newAEAD(password, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(password, salt, kdfTime, kdfMemory, kdfThreads, keySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and authenticates msg with a key derived from the
+// password, returning the sealed message.  The sealed message is
+// Overhead bytes longer than msg.
+func Seal(msg, password []byte) ([]byte, error) {
+	if int64(len(msg))+tagSize > 1<<32-1 {
+		return nil, errors.New("message too large to seal")
+	}
+	header := make([]byte, headerSize)
+	header[0] = version
+	salt := header[1 : 1+saltSize]
+	nonce := header[1+saltSize : 1+saltSize+nonceSize]
+	if _, err := rand.Read(header[1 : 1+saltSize+nonceSize]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(header[headerSize-4:], uint32(len(msg)+tagSize))
+	aead, err := newAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, msg, header), nil
+}
+
+// Open reads a sealed message from r and returns the original message
+// after authenticating it with a key derived from the password.  Only
+// as many bytes as the sealed message occupies are read from r.
+//
+// Returns ErrAuth if the message can't be authenticated, including if r
+// ends before the whole sealed message could be read.  Other errors
+// from r are returned as is.
+func Open(r io.Reader, password []byte) ([]byte, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrAuth
+		}
+		return nil, err
+	}
+	if header[0] != version {
+		return nil, ErrAuth
+	}
+	salt := header[1 : 1+saltSize]
+	nonce := header[1+saltSize : 1+saltSize+nonceSize]
+	n := int64(binary.BigEndian.Uint32(header[headerSize-4:]))
+	if n < tagSize {
+		return nil, ErrAuth
+	}
+	// Don't trust the length enough to allocate it all up front; it
+	// could be garbage.
+	buf := new(bytes.Buffer)
+	_, err = io.CopyN(buf, r, n)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrAuth
+		}
+		return nil, err
+	}
+	aead, err := newAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := aead.Open(nil, nonce, buf.Bytes(), header)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return msg, nil
+}
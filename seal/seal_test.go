@@ -0,0 +1,90 @@
+// chris 101826
+
+package seal
+
+import (
+	"bytes"
+	"testing"
+
+	"crypto/rand"
+
+	"chrispennello.com/go/steg"
+)
+
+func testSealOpen(t *testing.T, msg []byte) {
+	password := []byte("correct horse battery staple")
+	sealed, err := Seal(msg, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(msg)+Overhead {
+		t.Errorf("sealed size %v (expected %v)", len(sealed), len(msg)+Overhead)
+	}
+	if len(msg) > 8 && bytes.Contains(sealed, msg) {
+		t.Errorf("sealed message contains plaintext")
+	}
+
+	// Trailing data should be left alone.
+	r := bytes.NewReader(append(sealed, "trailing"...))
+	test, err := Open(r, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to open %#v (got %#v)", msg, test)
+	}
+	if r.Len() != len("trailing") {
+		t.Errorf("read %v bytes past sealed message", len("trailing")-r.Len())
+	}
+
+	if _, err := Open(bytes.NewReader(sealed), []byte("wrong")); err != ErrAuth {
+		t.Errorf("wrong password: %v (expected %v)", err, ErrAuth)
+	}
+	for _, i := range []int{0, 1, headerSize - 1, len(sealed) - 1} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0x01
+		if _, err := Open(bytes.NewReader(tampered), password); err != ErrAuth {
+			t.Errorf("tampered byte %v: %v (expected %v)", i, err, ErrAuth)
+		}
+	}
+	if _, err := Open(bytes.NewReader(sealed[:len(sealed)-1]), password); err != ErrAuth {
+		t.Errorf("truncated: %v (expected %v)", err, ErrAuth)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	testSealOpen(t, []byte("top secret!"))
+	testSealOpen(t, []byte{})
+	msg := make([]byte, 1000)
+	if _, err := rand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+	testSealOpen(t, msg)
+}
+
+func TestSealMux(t *testing.T) {
+	ctx := steg.NewCtx(1)
+	password := []byte("hunter2")
+	msg := []byte("attack at dawn")
+	sealed, err := Seal(msg, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for a few atoms past the sealed message.
+	carrier := make([]byte, (len(sealed)+3)*32)
+	if _, err := rand.Read(carrier); err != nil {
+		t.Fatal(err)
+	}
+	dst := new(bytes.Buffer)
+	err = ctx.NewMux(dst, bytes.NewReader(carrier), bytes.NewReader(sealed)).Mux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	test, err := Open(ctx.NewReader(dst), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("failed to open %#v (got %#v)", msg, test)
+	}
+}
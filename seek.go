@@ -0,0 +1,112 @@
+// chris 101826 Random-access extraction.
+
+package steg
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrSeek is returned by SeekReader.Seek for an invalid whence or a
+// resulting negative offset.
+var ErrSeek = errors.New("invalid seek")
+
+// A SeekReader reads steganographically-embedded bytes from a source
+// io.ReaderAt, like a Reader, but at any message offset, reading only
+// the chunks holding the bytes asked for.  Implements io.Reader,
+// io.ReaderAt, and io.Seeker.
+type SeekReader struct {
+	ctx *Ctx
+
+	src io.ReaderAt
+	// Carrier offset of the first chunk.
+	off int64
+	// Number of message bytes in the carrier.
+	size int64
+	// Message offset of the next Read.
+	pos int64
+}
+
+// NewSeekReader returns a fresh SeekReader, ready to read bytes
+// embedded in the n carrier bytes of src starting at the carrier offset
+// off, like io.NewSectionReader.  The message holds an atom for each
+// whole chunk of those.
+func (ctx *Ctx) NewSeekReader(src io.ReaderAt, off, n int64) *SeekReader {
+	size := n / ctx.chunkSize * int64(ctx.atomSize)
+	return &SeekReader{ctx: ctx, src: src, off: off, size: size}
+}
+
+// Size returns the number of message bytes in the carrier.
+func (r *SeekReader) Size() int64 {
+	return r.size
+}
+
+// readAtom reads the atom with the given index, through buf.
+func (r *SeekReader) readAtom(ai int64, buf []byte) (*atom, error) {
+	cs := r.ctx.chunkSize
+	a, err := r.ctx.readAtomStream(nil, io.NewSectionReader(r.src, r.off+ai*cs, cs), buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return a, err
+}
+
+// ReadAt reads len(p) embedded bytes starting at the message offset
+// off.  Like io.ReaderAt, returns io.EOF if fewer bytes than that are
+// left.  Safe for concurrent use.
+func (r *SeekReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, ErrSeek
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if rem := r.size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	as := int64(r.ctx.atomSize)
+	buf := make([]byte, r.ctx.bufSize())
+	for n < len(p) {
+		pos := off + int64(n)
+		a, aerr := r.readAtom(pos/as, buf)
+		if aerr != nil {
+			return n, aerr
+		}
+		n += copy(p[n:], a.data[pos%as:])
+	}
+	return n, err
+}
+
+// Read reads embedded bytes from the current offset, like Reader.Read,
+// and advances it by the number read.  Returns io.EOF at the end of the
+// message.
+func (r *SeekReader) Read(p []byte) (n int, err error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	n, err = r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the message offset of the next Read, like io.Seeker.
+func (r *SeekReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.pos, ErrSeek
+	}
+	if offset < 0 {
+		return r.pos, ErrSeek
+	}
+	r.pos = offset
+	return offset, nil
+}
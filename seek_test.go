@@ -0,0 +1,83 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testSeekReader(t *testing.T, atomSize uint8, planes byte, natoms int) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	msg := make([]byte, natoms*int(atomSize))
+	offset := int64(mathrand.Intn(10))
+	carrier := make([]byte, offset+int64(len(msg)/int(atomSize))*ctx.chunkSize+int64(mathrand.Intn(3)))
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrier), bytes.NewReader(msg))
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ctx.NewSeekReader(bytes.NewReader(dst.Bytes()), offset, int64(dst.Len())-offset)
+	if r.Size() != int64(len(msg)) {
+		t.Fatalf("size %v (expected %v)", r.Size(), len(msg))
+	}
+	for i := 0; i < 10; i++ {
+		lo := mathrand.Intn(len(msg))
+		hi := lo + mathrand.Intn(len(msg)-lo) + 1
+		p := make([]byte, hi-lo)
+		n, err := r.ReadAt(p, int64(lo))
+		if n != len(p) || err != nil {
+			t.Fatalf("ReadAt(%v) = %v, %v", lo, n, err)
+		}
+		if !bytes.Equal(p, msg[lo:hi]) {
+			t.Errorf("ReadAt(%v) read %#v (expected %#v)", lo, p, msg[lo:hi])
+		}
+	}
+
+	// Past the end.
+	p := make([]byte, 2)
+	n, err := r.ReadAt(p, int64(len(msg)-1))
+	if n != 1 || err != io.EOF || p[0] != msg[len(msg)-1] {
+		t.Errorf("ReadAt at end = %v, %v", n, err)
+	}
+
+	// Seek and Read the tail.
+	lo := mathrand.Intn(len(msg))
+	pos, err := r.Seek(int64(lo-len(msg)), io.SeekEnd)
+	if pos != int64(lo) || err != nil {
+		t.Fatalf("Seek = %v, %v (expected %v)", pos, err, lo)
+	}
+	test := new(bytes.Buffer)
+	if _, err := io.Copy(test, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test.Bytes(), msg[lo:]) {
+		t.Errorf("read %#v (expected %#v)", test.Bytes(), msg[lo:])
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err != ErrSeek {
+		t.Errorf("negative Seek err = %v (expected ErrSeek)", err)
+	}
+}
+
+func TestSeekReader(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		testSeekReader(t, 1, 0xff, mathrand.Intn(20)+1)
+		testSeekReader(t, 2, 0xff, mathrand.Intn(20)+1)
+		testSeekReader(t, 2, 0x03, mathrand.Intn(5)+1)
+	}
+	// Streamed chunks.
+	testSeekReader(t, 3, 0xff, 2)
+}
@@ -0,0 +1,73 @@
+// chris 101826
+
+package steg
+
+import (
+	"math/bits"
+)
+
+// Stats records what a Writer has embedded.
+type Stats struct {
+	// Chunks embedded in.
+	Chunks int64 `json:"chunks"`
+	// Carrier bits flipped.
+	Flips int64 `json:"flips"`
+	// Chunks left unchanged because their atom already matched.
+	NoOps int64 `json:"noops"`
+	// Carrier bytes copied through by Copy and CopyN.
+	PassedThrough int64 `json:"passedThrough"`
+	// Where the flipped bits are, if recorded; see
+	// Writer.RecordPositions.
+	Positions []FlipPos `json:"positions,omitempty"`
+}
+
+// A FlipPos is the position of a flipped carrier bit.
+type FlipPos struct {
+	// Offset of the carrier byte, counting from the first byte the
+	// Writer read, including any copied through.
+	Offset int64 `json:"offset"`
+	// Bit sub-index within the byte, from least significant.
+	Bit uint8 `json:"bit"`
+}
+
+// Stats returns what the Writer has embedded so far.
+func (w *Writer) Stats() Stats {
+	return w.stats
+}
+
+// RecordPositions makes the Writer record the position of each bit it
+// flips from now on, in Stats.Positions.  That's one for each chunk, so
+// it may be a lot.
+func (w *Writer) RecordPositions() {
+	w.positions = true
+}
+
+// Stats is like Writer.Stats.
+func (m *Mux) Stats() Stats {
+	return m.w.Stats()
+}
+
+// RecordPositions is like Writer.RecordPositions.
+func (m *Mux) RecordPositions() {
+	m.w.RecordPositions()
+}
+
+// noteFlips records the embedding of an atom in the next chunk, by
+// flipping the bits at the given chunk bit indexes, if any.
+func (w *Writer) noteFlips(cbis ...uint64) {
+	w.stats.Chunks++
+	if len(cbis) == 0 {
+		w.stats.NoOps++
+	}
+	w.stats.Flips += int64(len(cbis))
+	if !w.positions {
+		return
+	}
+	// The chunk hasn't been counted as consumed yet.
+	start := w.prog.p.CarrierBytes
+	for _, cbi := range cbis {
+		Bi, mask := w.ctx.bitPos(cbi)
+		pos := FlipPos{Offset: start + Bi, Bit: uint8(bits.TrailingZeros8(mask))}
+		w.stats.Positions = append(w.stats.Positions, pos)
+	}
+}
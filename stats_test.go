@@ -0,0 +1,94 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testStats(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	natoms := mathrand.Intn(20) + 1
+	msg := make([]byte, natoms*int(atomSize))
+	offset := int64(mathrand.Intn(10))
+	carrier := make([]byte, offset+int64(natoms)*ctx.chunkSize+int64(mathrand.Intn(10)))
+	if _, err := cryptorand.Read(carrier); err != nil {
+		t.Fatal(err)
+	}
+	// Make some atoms match already.
+	r := ctx.NewReader(bytes.NewReader(carrier[offset:]))
+	if _, err := r.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(msg); i++ {
+		if mathrand.Intn(2) == 0 {
+			msg[i] ^= byte(mathrand.Intn(256))
+		}
+	}
+
+	dst := new(bytes.Buffer)
+	m := ctx.NewMux(dst, bytes.NewReader(carrier), bytes.NewReader(msg))
+	m.RecordPositions()
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	s := m.Stats()
+
+	var expect []FlipPos
+	for i, B := range dst.Bytes() {
+		for bsi := uint8(0); bsi < 8; bsi++ {
+			if (B^carrier[i])&(1<<bsi) != 0 {
+				expect = append(expect, FlipPos{Offset: int64(i), Bit: bsi})
+			}
+		}
+	}
+	if len(s.Positions) != len(expect) {
+		t.Fatalf("positions %v (expected %v)", s.Positions, expect)
+	}
+	for i := range expect {
+		if s.Positions[i] != expect[i] {
+			t.Fatalf("positions %v (expected %v)", s.Positions, expect)
+		}
+	}
+	noops := int64(0)
+	for i := 0; i < natoms; i++ {
+		data := carrier[offset+int64(i)*ctx.chunkSize:][:ctx.chunkSize]
+		a := (&chunk{ctx: ctx, data: data}).readAtom()
+		if bytes.Equal(a.data, msg[i*int(atomSize):(i+1)*int(atomSize)]) {
+			noops++
+		}
+	}
+	if s.Chunks != int64(natoms) || s.Flips != int64(natoms)-noops || s.NoOps != noops {
+		t.Errorf("stats %+v (expected %v chunks, %v no-ops)", s, natoms, noops)
+	}
+	if pt := int64(len(carrier)) - int64(natoms)*ctx.chunkSize; s.PassedThrough != pt {
+		t.Errorf("passed through %v (expected %v)", s.PassedThrough, pt)
+	}
+
+	// So does a wet Writer.
+	if atomSize > 3 {
+		return
+	}
+	wm := ctx.NewWetMux(new(bytes.Buffer), bytes.NewReader(carrier[offset:]), bytes.NewReader(nil), bytes.NewReader(msg))
+	if err := wm.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if s := wm.Stats(); s.Chunks != int64(natoms) || s.NoOps != noops || s.Flips < int64(natoms)-noops {
+		t.Errorf("wet stats %+v (expected %v chunks, %v no-ops)", s, natoms, noops)
+	}
+}
+
+func TestStats(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		testStats(t, 1, 0xff)
+		testStats(t, 2, 0xff)
+		testStats(t, 2, 0x06)
+	}
+}
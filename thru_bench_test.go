@@ -4,6 +4,7 @@ package steg
 
 import (
 	"bytes"
+	"runtime"
 	"testing"
 
 	"crypto/rand"
@@ -34,6 +35,7 @@ func benchmarkSetup(b *testing.B, atomSize uint8) (ctx *Ctx, carrierBytes, msgBy
 
 func benchmarkN(b *testing.B, atomSize uint8) {
 	ctx, carrierBytes, msgBytes := benchmarkSetup(b, atomSize)
+	b.SetBytes(benchSize)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		carrier := bytes.NewBuffer(carrierBytes)
@@ -46,3 +48,36 @@ func benchmarkN(b *testing.B, atomSize uint8) {
 func Benchmark1(b *testing.B) { benchmarkN(b, 1) }
 func Benchmark2(b *testing.B) { benchmarkN(b, 2) }
 func Benchmark3(b *testing.B) { benchmarkN(b, 3) }
+
+func benchmarkReadN(b *testing.B, atomSize uint8) {
+	ctx, carrierBytes, msgBytes := benchmarkSetup(b, atomSize)
+	b.SetBytes(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src := bytes.NewBuffer(carrierBytes)
+		if _, err := ctx.NewReader(src).Read(msgBytes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark reading by atom size.
+func BenchmarkRead1(b *testing.B) { benchmarkReadN(b, 1) }
+func BenchmarkRead2(b *testing.B) { benchmarkReadN(b, 2) }
+func BenchmarkRead3(b *testing.B) { benchmarkReadN(b, 3) }
+
+func benchmarkParallelN(b *testing.B, atomSize uint8) {
+	ctx, carrierBytes, msgBytes := benchmarkSetup(b, atomSize)
+	b.SetBytes(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		carrier := bytes.NewBuffer(carrierBytes)
+		msg := bytes.NewBuffer(msgBytes)
+		ctx.NewParallelMux(ioutil.Discard, carrier, msg, runtime.GOMAXPROCS(0)).Mux()
+	}
+}
+
+// Benchmark muxing on all processors by atom size.
+func BenchmarkParallel1(b *testing.B) { benchmarkParallelN(b, 1) }
+func BenchmarkParallel2(b *testing.B) { benchmarkParallelN(b, 2) }
+func BenchmarkParallel3(b *testing.B) { benchmarkParallelN(b, 3) }
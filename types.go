@@ -4,17 +4,25 @@ package steg
 
 import "io"
 
-// A Ctx is a context that encapsulates the desired atom size.  Create
-// atoms, chunks, Readers, Writers, and Muxes from a context.
+// A Ctx is a context that encapsulates the desired atom size and the
+// carrier bit planes in use.  Create atoms, chunks, Readers, Writers,
+// and Muxes from a context.
 type Ctx struct {
-	// Atom size at most 3, so it will fit in a uint8.
+	// Atom size at most 7, so it will fit in a uint8.
 	atomSize uint8 // in bytes
-	// Chunk size at most 2Mi, so it will fit in a uint32.
-	chunkSize uint32 // in bytes
-	// In general, it's safe to cast either of these to an int.
-
-	// Even a chunk bit index will be at most 2Mi * 8 - 1 =
-	// 16Mi - 1, which will fit in a uint32.
+	// Chunk size at most 2^56 bytes (atom size 7, one bit plane),
+	// so it will fit in an int64.
+	chunkSize int64 // in bytes
+
+	// Even a chunk bit index will be at most 2^56 - 1, which will
+	// fit in a uint64.  This is an index into the carrier bits
+	// selected by planes, not into the chunk bytes themselves.
+
+	// Mask of the bit planes of each carrier byte that are
+	// considered carrier bits.  0xff for all of them.
+	planes byte
+	// Bit sub-indexes of the selected planes, in ascending order.
+	planeBits []uint8
 }
 
 type atom struct {
@@ -39,6 +47,13 @@ type Reader struct {
 	// Remaining bytes before the current atom is exhausted and we
 	// need to get another one.
 	cn int
+
+	// Chunk or window buffer, reused across reads.
+	buf []byte
+	// Message buffer, reused across WriteTo calls.
+	msgBuf []byte
+	// How far we've got; see Watch.
+	prog progress
 }
 
 // A Writer enables you to write steganographically-embedded bytes into
@@ -49,6 +64,28 @@ type Writer struct {
 
 	dst     io.Writer
 	carrier io.Reader
+
+	// Chunk or window buffer, reused across writes.
+	buf []byte
+	// Message buffer, reused across ReadFrom calls.
+	msgBuf []byte
+	// How far we've got; see Watch.
+	prog progress
+	// What's been embedded, and whether to record flip positions;
+	// see Stats.
+	stats     Stats
+	positions bool
+	// Whole chunk, if one must be held in memory after all.
+	c *chunk
+	// Dry mask of the carrier, if any, and the current chunk's; see
+	// NewWetWriter.
+	dry     io.Reader
+	dryMask []byte
+	// Carrier to embed into in place, if any; see NewInPlaceWriter.
+	inPlace io.ReadWriteSeeker
+	// Whether the destination can be patched; see writePatch.
+	// Decided on the first streamed write.
+	patch, patchKnown bool
 }
 
 // Mux multiplexes a message on a carrier into a destination.  It
@@ -62,26 +99,107 @@ type Mux struct {
 }
 
 // NewCtx returns a fresh Ctx, ready to create the other types.  Panics
-// if atomSize is not 1, 2, or 3.
+// if atomSize is not between 1 and 7.  All eight bit planes of the
+// carrier are used.
+//
+// Chunks grow exponentially with the atom size: 512MiB at atom size 4,
+// and 128GiB at atom size 5.  Chunks bigger than 64KiB are streamed
+// rather than held in memory, and above atom size 3, writing requires
+// a seekable carrier; see Writer.Write.
 func NewCtx(atomSize uint8) *Ctx {
+	return NewPlaneCtx(atomSize, 0xff)
+}
+
+// NewPlaneCtx returns a fresh Ctx like NewCtx, but only the bit planes
+// selected by the planes mask are considered carrier bits.  Bit i of
+// planes selects bit i of every carrier byte; e.g., 0x01 restricts
+// embedding to the least-significant bits, and 0x03 to the two
+// least-significant bits.  Unselected bits are never read or flipped.
+//
+// Fewer planes mean more carrier bytes per chunk, and so a smaller
+// capacity for a carrier of a given size.  Panics if atomSize is not
+// between 1 and 7, or if planes is zero.
+func NewPlaneCtx(atomSize uint8, planes byte) *Ctx {
 	if atomSize < 1 {
 		panic("inappropriate atom size")
 	}
-	// NB: atomSize <= 3 depended on elsewhere in the code for type
+	// NB: atomSize <= 7 depended on elsewhere in the code for type
 	// safety.
-	if atomSize > 3 {
-		// See the chunk.ReadBit implementation.
+	if atomSize > 7 {
+		// Chunk bit indexes would overflow a uint64.
 		panic("unsupported atom size")
 	}
-	// (2 ^ (atomSize * 8)) / 8
-	chunkSize := uint32(1) << (atomSize*8 - 3)
-	return &Ctx{atomSize: atomSize, chunkSize: chunkSize}
+	if planes == 0 {
+		panic("no bit planes")
+	}
+	var planeBits []uint8
+	for bsi := uint8(0); bsi < 8; bsi++ {
+		if planes&(1<<bsi) != 0 {
+			planeBits = append(planeBits, bsi)
+		}
+	}
+	// 2 ^ (atomSize * 8) carrier bits, spread across as many bytes
+	// as it takes at len(planeBits) bits per byte.  If the number
+	// of planes doesn't evenly divide the number of carrier bits,
+	// the excess bits of the final byte go unused.
+	chunkBits := int64(1) << (atomSize * 8)
+	nplanes := int64(len(planeBits))
+	chunkSize := (chunkBits + nplanes - 1) / nplanes
+	return &Ctx{
+		atomSize:  atomSize,
+		chunkSize: chunkSize,
+		planes:    planes,
+		planeBits: planeBits,
+	}
+}
+
+// AtomSize returns the atom size of the context, in bytes.
+func (ctx *Ctx) AtomSize() uint8 {
+	return ctx.atomSize
+}
+
+// Planes returns the mask of carrier bit planes of the context.
+func (ctx *Ctx) Planes() byte {
+	return ctx.planes
+}
+
+// chunkBits returns the number of carrier bits in a chunk.
+func (ctx *Ctx) chunkBits() uint64 {
+	return uint64(1) << (ctx.atomSize * 8)
+}
+
+// bufSize returns the size of the buffer through which chunks are
+// read: either a whole chunk, or a window of a streamed one.
+func (ctx *Ctx) bufSize() int64 {
+	if ctx.streamed() {
+		return window
+	}
+	return ctx.chunkSize
+}
+
+// hugeChunks returns whether chunks are too big to ever hold in
+// memory.
+func (ctx *Ctx) hugeChunks() bool {
+	return ctx.atomSize > 3
+}
+
+// streamed returns whether chunks are bigger than a window, and so are
+// streamed through one rather than read whole.
+func (ctx *Ctx) streamed() bool {
+	return ctx.chunkSize > window
 }
 
 func (ctx *Ctx) newAtom() *atom {
 	return &atom{ctx: ctx, data: make([]byte, ctx.atomSize)}
 }
 
+// newMsgBuf returns a buffer for message bytes, whose size is a
+// multiple of the atom size.
+func (ctx *Ctx) newMsgBuf() []byte {
+	n := msgBufSize / int(ctx.atomSize) * int(ctx.atomSize)
+	return make([]byte, n)
+}
+
 func (ctx *Ctx) newChunk() *chunk {
 	return &chunk{ctx: ctx, data: make([]byte, ctx.chunkSize)}
 }
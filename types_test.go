@@ -31,8 +31,47 @@ func testNewCtx(t *testing.T, atomSize uint8) {
 
 func TestNewCtx(t *testing.T) {
 	testNewCtxPanic(t, 0)
-	testNewCtxPanic(t, 4)
+	testNewCtxPanic(t, 8)
 	testNewCtx(t, 1)
 	testNewCtx(t, 2)
 	testNewCtx(t, 3)
+	// Too big to allocate a chunk.
+	if ctx := NewCtx(4); ctx.chunkSize != 512*1024*1024 || !ctx.hugeChunks() {
+		t.Errorf("chunk size %v for atom size 4", ctx.chunkSize)
+	}
+	if ctx := NewPlaneCtx(7, 0x01); ctx.chunkSize != 1<<56 {
+		t.Errorf("chunk size %v for atom size 7, planes 0x01", ctx.chunkSize)
+	}
+}
+
+func testNewPlaneCtx(t *testing.T, atomSize uint8, planes byte, expect int64) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	if ctx.chunkSize != expect {
+		t.Errorf("chunk size %v for atom size %v, planes %#x (expected %v)",
+			ctx.chunkSize, atomSize, planes, expect)
+	}
+	c := ctx.newChunk()
+	if len(c.bits())*8 != int(ctx.chunkBits()) {
+		t.Errorf("packed %v bits (expected %v)", len(c.bits())*8, ctx.chunkBits())
+	}
+}
+
+func testNewPlaneCtxPanic(t *testing.T, atomSize uint8, planes byte) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(r)
+		}
+	}()
+	NewPlaneCtx(atomSize, planes)
+}
+
+func TestNewPlaneCtx(t *testing.T) {
+	testNewPlaneCtxPanic(t, 1, 0)
+	testNewPlaneCtxPanic(t, 8, 0x01)
+	testNewPlaneCtx(t, 1, 0xff, 32)
+	testNewPlaneCtx(t, 1, 0x01, 256)
+	testNewPlaneCtx(t, 1, 0x03, 128)
+	testNewPlaneCtx(t, 1, 0x07, 86)
+	testNewPlaneCtx(t, 2, 0x80, 64*1024)
+	testNewPlaneCtx(t, 2, 0x0f, 16*1024)
 }
@@ -0,0 +1,167 @@
+// chris 101826 Wet paper embedding.
+
+package steg
+
+import (
+	"errors"
+	"io"
+
+	"io/ioutil"
+	"math/bits"
+)
+
+// ErrWet is returned by Writer.Write when a chunk has too few dry bits
+// to embed an atom.
+var ErrWet = errors.New("chunk too wet to embed atom")
+
+// NewWetWriter returns a fresh Writer like NewWriter, which only ever
+// flips the carrier bits that the dry mask allows.  The dry mask is
+// read from dry in step with the carrier, a byte for each carrier byte;
+// bit i of a mask byte is set if bit i of the carrier byte is dry, and
+// so may be flipped, and clear if it's wet, and so must not be.  A
+// per-byte mask is simply 0xff for dry bytes and 0x00 for wet ones.
+// Carrier bytes beyond the end of the dry mask are dry.
+//
+// A Reader doesn't need the mask to read what a wet Writer wrote.
+// Instead of the one bit chosen by the atom, a chunk may have several
+// dry bits flipped, whose chunk bit indexes xor to the same thing.  If
+// there are no such bits, Write returns ErrWet.
+//
+// Panics if the atom size is above 3, since each chunk and its mask are
+// held in memory.
+func (ctx *Ctx) NewWetWriter(dst io.Writer, carrier, dry io.Reader) *Writer {
+	if ctx.hugeChunks() {
+		panic("unsupported atom size")
+	}
+	w := ctx.NewWriter(dst, carrier)
+	w.dry = dry
+	return w
+}
+
+// NewWetMux returns a fresh Mux like NewMux, but with a Writer from
+// NewWetWriter.
+func (ctx *Ctx) NewWetMux(dst io.Writer, carrier, dry, msg io.Reader) *Mux {
+	w := ctx.NewWetWriter(dst, carrier, dry)
+	return &Mux{ctx: ctx, w: w, msg: msg}
+}
+
+// readDry reads the dry mask of the next len(p) carrier bytes into p.
+func (w *Writer) readDry(p []byte) error {
+	n, err := io.ReadFull(w.dry, p)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		for i := n; i < len(p); i++ {
+			p[i] = 0xff
+		}
+		err = nil
+	}
+	return err
+}
+
+// discardDry skips the dry mask of the next n carrier bytes.
+func (w *Writer) discardDry(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, w.dry, n)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// isDry returns whether the bit at the chunk bit index cbi is set in
+// the packed dry bits.
+func isDry(dry []byte, cbi uint64) bool {
+	return dry[cbi>>3]&(1<<(cbi&0x7)) != 0
+}
+
+// wetFlips returns chunk bit indexes of dry bits whose xor is x, as few
+// as it readily can: one if bit x is itself dry, then two if any pair
+// will do, and otherwise as many as Gaussian elimination over the dry
+// bit indexes takes, at most one per atom bit.  Returns ErrWet if there
+// are none.
+func wetFlips(dry []byte, x uint64) ([]uint64, error) {
+	if x == 0 {
+		// Nothing to flip.
+		return nil, nil
+	}
+	if isDry(dry, x) {
+		return []uint64{x}, nil
+	}
+	for Bi, B := range dry {
+		for ; B != 0; B &= B - 1 {
+			cbi := uint64(Bi)<<3 | uint64(bits.TrailingZeros8(B))
+			if isDry(dry, cbi^x) {
+				return []uint64{cbi, cbi ^ x}, nil
+			}
+		}
+	}
+
+	// basis[i] is a combination of dry bit indexes whose xor has i
+	// as its highest set bit, along with that xor.
+	var basis [64]struct {
+		v    uint64
+		comb map[uint64]bool
+	}
+	for Bi, B := range dry {
+		for ; B != 0; B &= B - 1 {
+			cbi := uint64(Bi)<<3 | uint64(bits.TrailingZeros8(B))
+			v, comb := cbi, map[uint64]bool{cbi: true}
+			for i := 63; i >= 0 && v != 0; i-- {
+				if v&(1<<uint(i)) == 0 {
+					continue
+				}
+				if basis[i].comb == nil {
+					basis[i].v, basis[i].comb = v, comb
+					break
+				}
+				v ^= basis[i].v
+				for j, in := range basis[i].comb {
+					if in {
+						comb[j] = !comb[j]
+					}
+				}
+			}
+			if v == 0 {
+				continue
+			}
+			// A new basis vector; see whether x is now within
+			// reach.
+			y, flips := x, map[uint64]bool{}
+			for i := 63; i >= 0 && y != 0; i-- {
+				if y&(1<<uint(i)) == 0 || basis[i].comb == nil {
+					continue
+				}
+				y ^= basis[i].v
+				for j, in := range basis[i].comb {
+					if in {
+						flips[j] = !flips[j]
+					}
+				}
+			}
+			if y != 0 {
+				continue
+			}
+			var cbis []uint64
+			for j, in := range flips {
+				if in {
+					cbis = append(cbis, j)
+				}
+			}
+			return cbis, nil
+		}
+	}
+	return nil, ErrWet
+}
+
+// writeWet writes the atom into the chunk, only flipping bits set in
+// the packed dry bits.  Returns the chunk bit indexes of the bits
+// flipped.
+func (c *chunk) writeWet(a *atom, dry []byte) ([]uint64, error) {
+	x := c.readAtom().asUint64() ^ a.asUint64()
+	flips, err := wetFlips(dry, x)
+	if err != nil {
+		return nil, err
+	}
+	for _, cbi := range flips {
+		c.flipBit(cbi)
+	}
+	return flips, nil
+}
@@ -0,0 +1,125 @@
+// chris 101826
+
+package steg
+
+import (
+	"bytes"
+	"testing"
+
+	cryptorand "crypto/rand"
+	mathrand "math/rand"
+)
+
+func testWet(t *testing.T, atomSize uint8, planes byte) {
+	ctx := NewPlaneCtx(atomSize, planes)
+	msg := make([]byte, (mathrand.Intn(10)+1)*int(atomSize))
+	offset := int64(mathrand.Intn(10))
+	carrier := make([]byte, offset+int64(len(msg)/int(atomSize)+1)*ctx.chunkSize)
+	for _, p := range [][]byte{msg, carrier} {
+		if _, err := cryptorand.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Half the bytes wet, the rest dry in only some bits, and the
+	// mask a little short of the carrier.
+	dry := make([]byte, len(carrier)-mathrand.Intn(5))
+	for i := range dry {
+		if mathrand.Intn(2) == 0 {
+			dry[i] = byte(mathrand.Intn(256))
+		}
+	}
+
+	dst := new(bytes.Buffer)
+	m := ctx.NewWetMux(dst, bytes.NewReader(carrier), bytes.NewReader(dry), bytes.NewReader(msg))
+	if _, err := m.CopyN(offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mux(); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != len(carrier) {
+		t.Fatalf("muxed %v bytes (expected %v)", dst.Len(), len(carrier))
+	}
+	for i, B := range dst.Bytes() {
+		mask := byte(0xff)
+		if i < len(dry) {
+			mask = dry[i]
+		}
+		if (B^carrier[i])&^(mask&planes) != 0 {
+			t.Fatalf("flipped wet bit at %v: %#x to %#x, dry %#x", i, carrier[i], B, mask)
+		}
+	}
+
+	r := ctx.NewReader(bytes.NewReader(dst.Bytes()))
+	if err := r.Discard(offset); err != nil {
+		t.Fatal(err)
+	}
+	test := make([]byte, len(msg))
+	if _, err := r.Read(test); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(test, msg) {
+		t.Errorf("read %#v (expected %#v)", test, msg)
+	}
+}
+
+func TestWet(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		testWet(t, 1, 0xff)
+		testWet(t, 1, 0x03)
+		testWet(t, 2, 0xff)
+		testWet(t, 2, 0x01)
+	}
+}
+
+func TestWetAllWet(t *testing.T) {
+	ctx := NewCtx(1)
+	carrier := make([]byte, ctx.chunkSize)
+	dry := make([]byte, ctx.chunkSize)
+	w := ctx.NewWetWriter(new(bytes.Buffer), bytes.NewReader(carrier), bytes.NewReader(dry))
+	n, err := w.Write([]byte{0x5a})
+	if n != 0 || err != ErrWet {
+		t.Errorf("n = %v, err = %v (expected 0, ErrWet)", n, err)
+	}
+	// Nothing to flip needs no dry bits.
+	w = ctx.NewWetWriter(new(bytes.Buffer), bytes.NewReader(carrier), bytes.NewReader(dry))
+	n, err = w.Write([]byte{0})
+	if n != 1 || err != nil {
+		t.Errorf("n = %v, err = %v (expected 1, nil)", n, err)
+	}
+}
+
+func testWetFlips(t *testing.T, dryBits []uint64, x uint64, expect int) {
+	dry := make([]byte, 32)
+	for _, cbi := range dryBits {
+		dry[cbi>>3] |= 1 << (cbi & 0x7)
+	}
+	flips, err := wetFlips(dry, x)
+	if err != nil {
+		t.Errorf("dry %v, x %v: %v", dryBits, x, err)
+		return
+	}
+	y := uint64(0)
+	for _, cbi := range flips {
+		if !isDry(dry, cbi) {
+			t.Errorf("dry %v, x %v: flipped wet bit %v", dryBits, x, cbi)
+		}
+		y ^= cbi
+	}
+	if y != x {
+		t.Errorf("dry %v, x %v: flips %v xor to %v", dryBits, x, flips, y)
+	}
+	if len(flips) != expect {
+		t.Errorf("dry %v, x %v: %v flips (expected %v)", dryBits, x, len(flips), expect)
+	}
+}
+
+func TestWetFlips(t *testing.T) {
+	testWetFlips(t, []uint64{5}, 5, 1)
+	testWetFlips(t, []uint64{1, 4}, 5, 2)
+	testWetFlips(t, []uint64{1, 2, 4}, 7, 3)
+	testWetFlips(t, []uint64{3, 5, 9, 17}, 3^5^9, 3)
+	if _, err := wetFlips(make([]byte, 32), 1); err != ErrWet {
+		t.Errorf("err = %v (expected ErrWet)", err)
+	}
+}
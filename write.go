@@ -16,10 +16,14 @@ var ErrShortCarrier = errors.New("not enough carrier data")
 // being used.
 var ErrInsufficientData = errors.New("data size not a multiple of atom size")
 
+// ErrUnseekable is returned by Writer.Write when chunks are streamed,
+// but the carrier isn't an io.Seeker.
+var ErrUnseekable = errors.New("carrier not seekable")
+
 // xorBit XORs the bit into the byte slice p given the specified bit
-// index bi.  Atom size is at most 3, so chunk size is at most 2Mi, so a
-// chunk bit index can be at most 16Mi - 1, so bi will fit in a uint32.
-func xorBit(p []byte, bit uint8, bi uint32) {
+// index bi.  Atom size is at most 7, so a chunk bit index can be at
+// most 2^56 - 1, so bi will fit in a uint64.
+func xorBit(p []byte, bit uint8, bi uint64) {
 	// The bits in bi above 3 tell us in which slice byte to xor the
 	// bit, and the low 3 bits tell us which bit in that byte this
 	// is for.
@@ -32,7 +36,7 @@ func xorBit(p []byte, bit uint8, bi uint32) {
 
 // xorBit xors the given bit at the given atom bit index.
 func (a *atom) xorBit(bit uint8, abi uint8) {
-	xorBit(a.data, bit, uint32(abi))
+	xorBit(a.data, bit, uint64(abi))
 }
 
 // xor performs an in-place xor of a with b.  Alters a.
@@ -58,12 +62,36 @@ func (a *atom) copy(data []byte) {
 	copy(a.data, data)
 }
 
-// write writes the atom into the chunk.
-func (c *chunk) write(a *atom) {
+// bitPos maps the chunk bit index cbi onto the selected bit planes,
+// returning the index of the chunk byte holding the bit, and the mask
+// of the bit within it.
+func (ctx *Ctx) bitPos(cbi uint64) (Bi int64, mask byte) {
+	if ctx.planes == 0xff {
+		return int64(cbi >> 3), 1 << (cbi & 0x7)
+	}
+	nplanes := uint64(len(ctx.planeBits))
+	return int64(cbi / nplanes), 1 << ctx.planeBits[cbi%nplanes]
+}
+
+// flipBit flips the carrier bit at the given chunk bit index.
+func (c *chunk) flipBit(cbi uint64) {
+	Bi, mask := c.ctx.bitPos(cbi)
+	c.data[Bi] ^= mask
+}
+
+// write writes the atom into the chunk.  Returns the chunk bit indexes
+// of the bits flipped: none if the atom already matched.
+func (c *chunk) write(a *atom) []uint64 {
 	// Compare current value with what we need to write.
-	x := c.readAtom().asUint32() ^ a.asUint32()
+	x := c.readAtom().asUint64() ^ a.asUint64()
 	// x is now a bit index to which bit in c we need to flip.
-	xorBit(c.data, 1, x)
+	// Index 0 doesn't count towards the atom, so it means that
+	// there's nothing to flip.
+	if x == 0 {
+		return nil
+	}
+	c.flipBit(x)
+	return []uint64{x}
 }
 
 // write writes chunk into destination io.Reader.
@@ -73,6 +101,200 @@ func (w *Writer) write(c *chunk) error {
 	return err
 }
 
+// readCarrier reads len(p) bytes from the carrier.  Returns
+// ErrShortCarrier if there aren't that many.
+func (w *Writer) readCarrier(p []byte) error {
+	_, err := io.ReadFull(w.carrier, p)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrShortCarrier
+	}
+	return err
+}
+
+// writeChunk embeds the atom in the next chunk, held whole in memory.
+func (w *Writer) writeChunk(a *atom) error {
+	if w.c == nil {
+		if w.ctx.streamed() {
+			w.c = w.ctx.newChunk()
+		} else {
+			w.c = &chunk{ctx: w.ctx, data: w.buf}
+		}
+	}
+	err := w.readCarrier(w.c.data)
+	if err != nil {
+		return err
+	}
+	if w.dry != nil {
+		if w.dryMask == nil {
+			w.dryMask = make([]byte, w.ctx.chunkSize)
+		}
+		err = w.readDry(w.dryMask)
+		if err != nil {
+			return err
+		}
+		dry, _ := w.ctx.pack(w.dryMask, w.ctx.chunkBits())
+		flips, err := w.c.writeWet(a, dry)
+		if err != nil {
+			return err
+		}
+		w.noteFlips(flips...)
+	} else {
+		w.noteFlips(w.c.write(a)...)
+	}
+	return w.write(w.c)
+}
+
+// copyChunk copies the next chunk from the carrier to the destination
+// through w.buf, a window at a time, folding it as it goes.  If Bi is
+// within the chunk, the bits of mask in the byte at that index are
+// flipped on the way through.  Checks for cancellation between windows.
+func (w *Writer) copyChunk(f *fold, Bi int64, mask byte) error {
+	nbits := w.ctx.chunkBits()
+	for off := int64(0); off < w.ctx.chunkSize; {
+		if err := canceled(w.prog.cx); err != nil {
+			return err
+		}
+		p := w.buf
+		if rem := w.ctx.chunkSize - off; int64(len(p)) > rem {
+			p = p[:rem]
+		}
+		err := w.readCarrier(p)
+		if err != nil {
+			return err
+		}
+		q, n := w.ctx.pack(p, nbits)
+		f.add(q)
+		nbits -= n
+		if Bi >= off && Bi < off+int64(len(p)) {
+			p[Bi-off] ^= mask
+		}
+		_, err = w.dst.Write(p)
+		if err != nil {
+			return err
+		}
+		off += int64(len(p))
+	}
+	return nil
+}
+
+// canPatch reports whether the destination can be patched in place,
+// as well as the carrier re-read: both must be files, or the like.
+func (w *Writer) canPatch() bool {
+	if !w.patchKnown {
+		_, cok := w.carrier.(interface {
+			io.ReaderAt
+			io.Seeker
+		})
+		dst, dok := w.dst.(interface {
+			io.WriterAt
+			io.Seeker
+		})
+		if cok && dok {
+			// Files opened for appending refuse WriteAt.
+			pos, err := dst.Seek(0, io.SeekCurrent)
+			if err == nil {
+				_, err = dst.WriteAt(nil, pos)
+			}
+			w.patch = err == nil
+		}
+		w.patchKnown = true
+	}
+	return w.patch
+}
+
+// writePatch embeds the atom in the next chunk in a single pass: the
+// chunk is copied through as is, and then the one bit to flip is
+// patched in the destination, from the byte at the same index in the
+// carrier.
+func (w *Writer) writePatch(a *atom) error {
+	carrier := w.carrier.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	dst := w.dst.(interface {
+		io.WriterAt
+		io.Seeker
+	})
+	cpos, err := carrier.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	dpos, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	var f fold
+	err = w.copyChunk(&f, -1, 0)
+	if err != nil {
+		return err
+	}
+	x := f.atom(w.ctx).asUint64() ^ a.asUint64()
+	if x == 0 {
+		w.noteFlips()
+		return nil
+	}
+	w.noteFlips(x)
+	Bi, mask := w.ctx.bitPos(x)
+	p := make([]byte, 1)
+	_, err = carrier.ReadAt(p, cpos+Bi)
+	if err != nil {
+		return err
+	}
+	p[0] ^= mask
+	_, err = dst.WriteAt(p, dpos+Bi)
+	return err
+}
+
+// writeStream embeds the atom in the next chunk in two passes: once to
+// find the bit to flip, and then again after seeking back to copy the
+// chunk through with it flipped.
+func (w *Writer) writeStream(a *atom) error {
+	s := w.carrier.(io.Seeker)
+	cur, err := w.ctx.readAtomStream(w.prog.cx, w.carrier, w.buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrShortCarrier
+	}
+	if err != nil {
+		return err
+	}
+	x := cur.asUint64() ^ a.asUint64()
+	_, err = s.Seek(-w.ctx.chunkSize, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if x == 0 {
+		w.noteFlips()
+		return w.copyChunk(new(fold), -1, 0)
+	}
+	w.noteFlips(x)
+	Bi, mask := w.ctx.bitPos(x)
+	return w.copyChunk(new(fold), Bi, mask)
+}
+
+// writeAtom embeds the atom in the next chunk, by whichever means the
+// chunk size, the carrier, and the destination allow.
+func (w *Writer) writeAtom(a *atom) error {
+	if w.buf == nil {
+		w.buf = make([]byte, w.ctx.bufSize())
+	}
+	if w.inPlace != nil {
+		return w.writeInPlace(a)
+	}
+	if !w.ctx.streamed() || w.dry != nil {
+		return w.writeChunk(a)
+	}
+	if w.canPatch() {
+		return w.writePatch(a)
+	}
+	if _, ok := w.carrier.(io.Seeker); ok {
+		return w.writeStream(a)
+	}
+	if !w.ctx.hugeChunks() {
+		return w.writeChunk(a)
+	}
+	return ErrUnseekable
+}
+
 // Write steganographically-embedded bytes to the destination io.Writer
 // using data from the carrier io.Reader.  Returns the number of bytes
 // written, as well as an error, if one occurred.
@@ -86,38 +308,85 @@ func (w *Writer) write(c *chunk) error {
 // the requested data.  Note that in this case, you're sort of sunk--we
 // couldn't read enough data from the carrier to embed some atom, so the
 // carrier data was therefore thrown away before being written to the
-// destination.
+// destination.  For streamed chunks, some of it may have been written
+// to the destination.
 //
 // n == len(p) iff err != nil
+//
+// Chunks of up to 64KiB are held in memory.  Bigger ones are streamed
+// through a window of that size, so memory use doesn't grow with the
+// atom size.  If the carrier is an io.ReaderAt and io.Seeker and the
+// destination an io.WriterAt and io.Seeker, as files are, each chunk is
+// copied through once, and the flipped bit then patched in the
+// destination.  Otherwise, if the carrier is an io.Seeker, each chunk
+// is read twice.  Otherwise, chunks are held in memory after all, up to
+// atom size 3; above it, ErrUnseekable is returned.
 func (w *Writer) Write(p []byte) (n int, err error) {
 	if len(p)%int(w.ctx.atomSize) != 0 {
 		return 0, ErrInsufficientData
 	}
-	c := w.ctx.newChunk()
 	a := w.ctx.newAtom()
 	for n < len(p) {
-		_, err = io.ReadFull(w.carrier, c.data)
+		a.copy(p[n : n+int(w.ctx.atomSize)])
+		err = w.writeAtom(a)
 		if err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				err = ErrShortCarrier
-			}
+			// We may have written _some_ of the bytes of
+			// the chunk, but won't have written all of
+			// them.  We consider this to be the atom _not_
+			// having been written, so n remains
+			// unincremented.
 			return n, err
 		}
-		a.copy(p[n : n+int(w.ctx.atomSize)])
-		c.write(a)
-		err = w.write(c)
+		n += int(w.ctx.atomSize)
+		err = w.prog.add(w.ctx, 1, w.ctx.chunkSize)
 		if err != nil {
-			// We may have written _some_ of the bytes of c,
-			// but won't have written all of them.  We
-			// consider this to be the atom _not_ having
-			// been written, so n remains unincremented.
 			return n, err
 		}
-		n += int(w.ctx.atomSize)
 	}
 	return n, nil
 }
 
+// ReadFrom embeds bytes read from r until it ends, like Write, and
+// returns the number embedded.  Implements io.ReaderFrom, so that
+// io.Copy into a Writer needn't align its buffer to atoms: message
+// bytes are buffered internally, a whole number of atoms at a time, and
+// the final partial atom, if any, is padded with zero bytes.
+//
+// Returns Write's errors, or any other than io.EOF from r.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	as := int(w.ctx.atomSize)
+	if w.msgBuf == nil {
+		w.msgBuf = w.ctx.newMsgBuf()
+	}
+	p := w.msgBuf
+	for {
+		nr, rerr := io.ReadFull(r, p)
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil && rerr != io.ErrUnexpectedEOF {
+			return n, rerr
+		}
+		// Pad the final partial atom with zero bytes.
+		end := (nr + as - 1) / as * as
+		for i := nr; i < end; i++ {
+			p[i] = 0
+		}
+		nw, werr := w.Write(p[:end])
+		if nw > nr {
+			// Don't count the padding.
+			nw = nr
+		}
+		n += int64(nw)
+		if werr != nil {
+			return n, werr
+		}
+		if rerr == io.ErrUnexpectedEOF {
+			return n, nil
+		}
+	}
+}
+
 // Copy copies from the carrier to the destination without doing any
 // steganographic embedding.  It's implemented by a simple call to
 // io.Copy.
@@ -125,7 +394,17 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 // The idea is that you'd call this to send through the rest of your
 // carrier data after you've finished successfully with any Writes.
 func (w *Writer) Copy() (written int64, err error) {
-	return io.Copy(w.dst, w.carrier)
+	if w.prog.watched() {
+		return copyWatched(w.CopyN)
+	}
+	if w.inPlace != nil {
+		written, err = w.skipInPlace(-1)
+	} else {
+		written, err = io.Copy(w.dst, w.carrier)
+	}
+	w.stats.PassedThrough += written
+	w.prog.add(w.ctx, 0, written)
+	return written, err
 }
 
 // CopyN copies n bytes from the carrier to the destination without
@@ -138,5 +417,20 @@ func (w *Writer) Copy() (written int64, err error) {
 //
 // Counterpart to Reader.Discard.
 func (w *Writer) CopyN(n int64) (written int64, err error) {
-	return io.CopyN(w.dst, w.carrier, n)
+	if w.inPlace != nil {
+		written, err = w.skipInPlace(n)
+	} else {
+		if w.dry != nil {
+			err = w.discardDry(n)
+			if err != nil {
+				return 0, err
+			}
+		}
+		written, err = io.CopyN(w.dst, w.carrier, n)
+	}
+	w.stats.PassedThrough += written
+	if perr := w.prog.add(w.ctx, 0, written); err == nil {
+		err = perr
+	}
+	return written, err
 }
@@ -7,13 +7,13 @@ import (
 	"strings"
 	"testing"
 
+	"math/bits"
+
 	cryptorand "crypto/rand"
 	mathrand "math/rand"
-
-	"chrispennello.com/go/swar"
 )
 
-func testXorBit(t *testing.T, p []byte, bit uint8, bitIndex uint32, expect []byte) {
+func testXorBit(t *testing.T, p []byte, bit uint8, bitIndex uint64, expect []byte) {
 	xorBit(p, bit, bitIndex)
 	if !bytes.Equal(p, expect) {
 		t.Fail()
@@ -57,11 +57,16 @@ func testChunkDiff(t *testing.T, a, b *chunk) {
 		panic("chunks with different contexts")
 	}
 	bitsDiff := 0
-	for i := uint32(0); i < a.ctx.chunkSize; i++ {
-		bitsDiff += int(swar.Ones8(a.data[i] ^ b.data[i]))
+	for i := int64(0); i < a.ctx.chunkSize; i++ {
+		bitsDiff += int(bits.OnesCount8(a.data[i] ^ b.data[i]))
 	}
-	if bitsDiff != 1 {
-		t.Errorf("%#v and %#v differ by more than 1 bit (by %v)", a.data, b.data, bitsDiff)
+	// A chunk whose atom already matched is left alone.
+	expectBits := 1
+	if bytes.Equal(a.readAtom().data, b.readAtom().data) {
+		expectBits = 0
+	}
+	if bitsDiff != expectBits {
+		t.Errorf("%#v and %#v differ by other than %v bit (by %v)", a.data, b.data, expectBits, bitsDiff)
 	}
 }
 
@@ -108,7 +113,9 @@ func TestWriteAtom(t *testing.T) {
 	}
 }
 
-func testBytesDiff(t *testing.T, a, b []byte, expectBits int) {
+// testBytesDiff checks that a and b differ by at most maxBits bits, one
+// for each chunk whose atom didn't already match.
+func testBytesDiff(t *testing.T, a, b []byte, maxBits int) {
 	var m int
 	if len(a) < len(b) {
 		m = len(a)
@@ -117,10 +124,10 @@ func testBytesDiff(t *testing.T, a, b []byte, expectBits int) {
 	}
 	bitsDiff := 0
 	for i := 0; i < m; i++ {
-		bitsDiff += int(swar.Ones8(a[i] ^ b[i]))
+		bitsDiff += int(bits.OnesCount8(a[i] ^ b[i]))
 	}
-	if bitsDiff != expectBits {
-		t.Errorf("not %v bit difference (is %v)", expectBits, bitsDiff)
+	if bitsDiff > maxBits {
+		t.Errorf("more than %v bit difference (is %v)", maxBits, bitsDiff)
 	}
 }
 